@@ -0,0 +1,237 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"go-p2p/enum/keyAlgorithm"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	CAFile    = "ca.pem"
+	CAKeyFile = "ca-key.pem"
+)
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return nil
+}
+
+func readPEM(path, blockType string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s does not contain a %s block", path, blockType)
+	}
+	return block.Bytes, nil
+}
+
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	der, err := readPEM(path, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	return cert, nil
+}
+
+// GenerateCA creates a network certificate authority in dir. Node
+// certificates signed by it are accepted by every node holding ca.pem. The CA
+// key is encrypted when passphrase is not empty.
+func GenerateCA(dir, name string, alg keyAlgorithm.Algorithm, passphrase []byte) error {
+	key, err := GenerateKey(alg)
+	if err != nil {
+		return fmt.Errorf("error generating CA key: %v", err)
+	}
+
+	serialNum, err := serialNumber()
+	if err != nil {
+		return fmt.Errorf("error generating serial num: %v", err)
+	}
+
+	tempCert := x509.Certificate{
+		SerialNumber: serialNum,
+		Subject: pkix.Name{
+			Organization: []string{"CC"},
+			CommonName:   name,
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(10 * 365 * 24 * time.Hour),
+
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tempCert, &tempCert, key.Public(), key)
+	if err != nil {
+		return fmt.Errorf("error generating CA cert: %v", err)
+	}
+
+	if err := writePEM(filepath.Join(dir, CAFile), "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+	return WritePrivateKey(filepath.Join(dir, CAKeyFile), key, passphrase)
+}
+
+func LoadCA(dir string, passphrase func() ([]byte, error)) (*x509.Certificate, crypto.Signer, error) {
+	certDER, err := readPEM(filepath.Join(dir, CAFile), "CERTIFICATE")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caCert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing CA cert: %v", err)
+	}
+
+	caKey, err := LoadPrivateKey(filepath.Join(dir, CAKeyFile), passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return caCert, caKey, nil
+}
+
+// LoadCABundle reads the CA certificates a node trusts. It returns a nil pool
+// and no error when the bundle does not exist, meaning the node is not part of
+// a closed network.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s contains no certificates", path)
+	}
+	return pool, nil
+}
+
+// CreateCSR builds a certificate signing request for a node key, to be signed
+// by the network CA with SignCSR.
+func CreateCSR(key crypto.Signer, host, port string) ([]byte, error) {
+	address := fmt.Sprintf("%s:%s", host, port)
+
+	tempCSR := x509.CertificateRequest{
+		Subject: pkix.Name{
+			Organization: []string{"CC"},
+			CommonName:   address,
+		},
+		DNSNames: []string{address},
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &tempCSR, key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating CSR: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// SignCSR issues a node certificate for csrPEM signed by the CA.
+func SignCSR(caCert *x509.Certificate, caKey crypto.Signer, csrPEM []byte, validity time.Duration) ([]byte, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("input is not a PEM certificate request")
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CSR: %v", err)
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %v", err)
+	}
+
+	serialNum, err := serialNumber()
+	if err != nil {
+		return nil, fmt.Errorf("error generating serial num: %v", err)
+	}
+
+	tempCert := x509.Certificate{
+		SerialNumber: serialNum,
+		Subject:      csr.Subject,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(validity),
+
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+		BasicConstraintsValid: true,
+		DNSNames:              csr.DNSNames,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tempCert, caCert, csr.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("error signing node cert: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// VerifyChain returns a tls.Config VerifyPeerCertificate callback that only
+// accepts certificates issued by a CA in roots.
+func VerifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("peer did not present a certificate")
+		}
+
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("invalid peer certificate: %v", err)
+			}
+			certs[i] = cert
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("peer certificate not issued by network CA: %v", err)
+		}
+
+		return nil
+	}
+}
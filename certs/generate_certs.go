@@ -1,70 +1,84 @@
 package certs
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/pem"
 	"fmt"
-	"math/big"
-	"os"
+	"go-p2p/enum/keyAlgorithm"
 	"path/filepath"
 	"time"
 )
 
-func GenerateCert(host, port string) {
-	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+const certValidity = 365 * 24 * time.Hour
+
+func nodeTemplate(key crypto.Signer, host, port string) (*x509.Certificate, error) {
 	address := fmt.Sprintf("%s:%s", host, port)
-	serialNum, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	serialNum, err := serialNumber()
 	if err != nil {
-		fmt.Println("Error generating serial num: ", err)
-		return
+		return nil, fmt.Errorf("error generating serial num: %v", err)
 	}
 
-	tempCert := x509.Certificate{
+	return &x509.Certificate{
 		SerialNumber: serialNum,
 		Subject: pkix.Name{
 			Organization: []string{"CC"},
 			CommonName:   address,
 		},
 		NotBefore: time.Now(),
-		NotAfter:  time.Now().Add(365 * 24 * time.Hour),
+		NotAfter:  time.Now().Add(certValidity),
 
-		KeyUsage: x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		KeyUsage: keyUsage(key),
 		ExtKeyUsage: []x509.ExtKeyUsage{
 			x509.ExtKeyUsageServerAuth,
 			x509.ExtKeyUsageClientAuth,
 		},
 		BasicConstraintsValid: true,
 		DNSNames:              []string{address},
+	}, nil
+}
+
+// selfSignedCert issues a self-signed node certificate for key.
+func selfSignedCert(key crypto.Signer, host, port string) ([]byte, error) {
+	tempCert, err := nodeTemplate(key, host, port)
+	if err != nil {
+		return nil, err
 	}
 
-	der, err := x509.CreateCertificate(rand.Reader, &tempCert, &tempCert, key.Public(), key)
+	der, err := x509.CreateCertificate(rand.Reader, tempCert, tempCert, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("error generating cert: %v", err)
+	}
 
+	return der, nil
+}
+
+// GenerateCert creates a new node key and self-signed certificate in dir.
+// The key is encrypted when passphrase is not empty.
+func GenerateCert(dir, host, port string, alg keyAlgorithm.Algorithm, passphrase []byte) error {
+	key, err := GenerateKey(alg)
 	if err != nil {
-		fmt.Println("Error generating cert: ", err)
+		return fmt.Errorf("error generating key: %v", err)
 	}
 
-	certsDir := filepath.Join("..", "certs")
+	der, err := selfSignedCert(key, host, port)
+	if err != nil {
+		return err
+	}
 
-	certPath := filepath.Join(certsDir, "cert.pem")
-	keyPath := filepath.Join(certsDir, "key.pem")
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
 	fmt.Println("Path: ", certPath)
 
-	certFile, err1 := os.Create(certPath)
-	keyFile, _ := os.Create(keyPath)
-
-	if err1 != nil {
-		fmt.Println("error,", err1)
+	if err := writePEM(certPath, "CERTIFICATE", der, 0644); err != nil {
+		return err
 	}
 
-	pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: der})
-	certFile.Close()
-
-	b := x509.MarshalPKCS1PrivateKey(key)
-	pem.Encode(keyFile, &pem.Block{Type: "PRIVATE KEY", Bytes: b})
-	keyFile.Close()
+	if err := WritePrivateKey(keyPath, key, passphrase); err != nil {
+		return err
+	}
 
 	fmt.Println("Generated cert.pem and key.pem")
+	return nil
 }
@@ -0,0 +1,259 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	ErrKeyMissing      = errors.New("private key not found")
+	ErrKeyCorrupt      = errors.New("private key file is corrupt")
+	ErrWrongPassphrase = errors.New("wrong passphrase for private key")
+)
+
+var (
+	oidPBES2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidScrypt    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// scrypt cost parameters for newly encrypted keys, roughly 100ms to unlock.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// encryptedPrivateKeyInfo and friends are the PKCS#8 / PKCS#5 PBES2
+// structures, with scrypt (RFC 7914) as the key derivation function.
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+func algorithmIdentifier(oid asn1.ObjectIdentifier, params any) (pkix.AlgorithmIdentifier, error) {
+	der, err := asn1.Marshal(params)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.RawValue{FullBytes: der}}, nil
+}
+
+// EncryptPKCS8 encrypts a PKCS#8 private key with a key derived from
+// passphrase, returning an EncryptedPrivateKeyInfo.
+func EncryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	plaintext := append(bytes.Clone(der), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+
+	kdf, err := algorithmIdentifier(oidScrypt, scryptParams{Salt: salt, CostParameter: scryptN, BlockSize: scryptR, ParallelizationParameter: scryptP, KeyLength: 32})
+	if err != nil {
+		return nil, err
+	}
+
+	scheme, err := algorithmIdentifier(oidAES256CBC, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, err := algorithmIdentifier(oidPBES2, pbes2Params{KeyDerivationFunc: kdf, EncryptionScheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: algorithm, EncryptedData: ciphertext})
+}
+
+// DecryptPKCS8 reverses EncryptPKCS8. Only PBES2 with scrypt and AES-256-CBC
+// is supported.
+func DecryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, ErrKeyCorrupt
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported key encryption %v", info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, ErrKeyCorrupt
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidScrypt) || !params.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+		return nil, fmt.Errorf("unsupported key encryption %v/%v", params.KeyDerivationFunc.Algorithm, params.EncryptionScheme.Algorithm)
+	}
+
+	var kdf scryptParams
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, ErrKeyCorrupt
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, ErrKeyCorrupt
+	}
+
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, ErrKeyCorrupt
+	}
+
+	key, err := scrypt.Key(passphrase, kdf.Salt, kdf.CostParameter, kdf.BlockSize, kdf.ParallelizationParameter, 32)
+	if err != nil {
+		return nil, ErrKeyCorrupt
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, info.EncryptedData)
+
+	// A bad passphrase almost always shows up as invalid padding or a
+	// plaintext that is not a PKCS#8 structure.
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ErrWrongPassphrase
+	}
+	plaintext = plaintext[:len(plaintext)-padding]
+
+	if _, err := x509.ParsePKCS8PrivateKey(plaintext); err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return plaintext, nil
+}
+
+// WritePrivateKey stores key as PKCS#8, encrypted when passphrase is not
+// empty. Any key x509.MarshalPKCS8PrivateKey supports can be stored.
+func WritePrivateKey(path string, key any, passphrase []byte) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("error encoding private key: %v", err)
+	}
+
+	if len(passphrase) == 0 {
+		return writePEM(path, "PRIVATE KEY", der, 0600)
+	}
+
+	encrypted, err := EncryptPKCS8(der, passphrase)
+	if err != nil {
+		return fmt.Errorf("error encrypting private key: %v", err)
+	}
+	return writePEM(path, "ENCRYPTED PRIVATE KEY", encrypted, 0600)
+}
+
+// LoadPrivateKey reads a signing key written by WritePrivateKey. passphrase
+// is only called for encrypted keys.
+func LoadPrivateKey(path string, passphrase func() ([]byte, error)) (crypto.Signer, error) {
+	der, err := loadPKCS8(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParsePrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrKeyCorrupt, path, err)
+	}
+	return key, nil
+}
+
+// LoadX25519Key reads an X25519 key agreement key written by WritePrivateKey.
+func LoadX25519Key(path string, passphrase func() ([]byte, error)) (*ecdh.PrivateKey, error) {
+	der, err := loadPKCS8(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrKeyCorrupt, path, err)
+	}
+
+	key, ok := parsed.(*ecdh.PrivateKey)
+	if !ok || key.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%w: %s is not an X25519 key", ErrKeyCorrupt, path)
+	}
+	return key, nil
+}
+
+func loadPKCS8(path string, passphrase func() ([]byte, error)) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyMissing, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: %s is not a PEM file", ErrKeyCorrupt, path)
+	}
+
+	der := block.Bytes
+	switch block.Type {
+	case "ENCRYPTED PRIVATE KEY":
+		pass, err := passphrase()
+		if err != nil {
+			return nil, fmt.Errorf("error reading passphrase: %v", err)
+		}
+
+		der, err = DecryptPKCS8(der, pass)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, path)
+		}
+	case "PRIVATE KEY", "RSA PRIVATE KEY":
+	default:
+		return nil, fmt.Errorf("%w: unexpected %s block in %s", ErrKeyCorrupt, block.Type, path)
+	}
+
+	return der, nil
+}
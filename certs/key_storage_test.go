@@ -0,0 +1,77 @@
+package certs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-p2p/enum/keyAlgorithm"
+)
+
+func passphrase(pass string) func() ([]byte, error) {
+	return func() ([]byte, error) { return []byte(pass), nil }
+}
+
+func TestPrivateKeyRoundTrip(t *testing.T) {
+	key, err := GenerateKey(keyAlgorithm.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := WritePrivateKey(path, key, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadPrivateKey(path, passphrase("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := Sign(got, []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(key.Public(), []byte("data"), sig); err != nil {
+		t.Fatalf("loaded a different key: %v", err)
+	}
+
+	if _, err := LoadPrivateKey(path, passphrase("wrong")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("wrong passphrase gave %v", err)
+	}
+}
+
+func TestPrivateKeyCorrupt(t *testing.T) {
+	key, err := GenerateKey(keyAlgorithm.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "key.pem")
+	if err := WritePrivateKey(path, key, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPrivateKey(path, passphrase("secret")); !errors.Is(err, ErrKeyCorrupt) {
+		t.Fatalf("truncated key gave %v", err)
+	}
+
+	if err := writePEM(path, "ENCRYPTED PRIVATE KEY", []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPrivateKey(path, passphrase("secret")); !errors.Is(err, ErrKeyCorrupt) {
+		t.Fatalf("garbage key gave %v", err)
+	}
+
+	if _, err := LoadPrivateKey(filepath.Join(dir, "missing.pem"), passphrase("secret")); !errors.Is(err, ErrKeyMissing) {
+		t.Fatalf("missing key gave %v", err)
+	}
+}
@@ -0,0 +1,308 @@
+package certs
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// PeerChangedError is returned when a peer presents a certificate whose
+// fingerprint differs from the one pinned on first contact.
+type PeerChangedError struct {
+	Identity string
+	Pinned   string
+	Received string
+}
+
+func (e *PeerChangedError) Error() string {
+	return fmt.Sprintf("certificate for %s has changed: pinned %s, received %s", e.Identity, e.Pinned, e.Received)
+}
+
+// BindingChangedError is returned when a different identity shows up at an
+// address or under a nickname first seen with another one.
+type BindingChangedError struct {
+	Binding  string
+	Value    string
+	Pinned   string
+	Received string
+}
+
+func (e *BindingChangedError) Error() string {
+	return fmt.Sprintf("%s %s belongs to %s, not %s", e.Binding, e.Value, e.Pinned, e.Received)
+}
+
+const (
+	bindAddress  = "address"
+	bindNickname = "nickname"
+	// pinCertificate marks a held certificate change in pending.
+	pinCertificate = "certificate"
+)
+
+// pin is a change to the store that waits for the user to accept it.
+type pin struct {
+	kind  string
+	value string
+}
+
+// KnownPeers is a trust-on-first-use store kept in a known_hosts style file.
+// It pins the certificate of each identity, with one "identity fingerprint"
+// per line, and binds each address and nickname to the identity first seen
+// with it, with "address identity host:port" and "nickname identity name"
+// lines. The identity is the fingerprint of the certificate's public key,
+// so a peer cannot choose which entry its certificate is checked against.
+// Changes are held as pending until the user accepts them.
+type KnownPeers struct {
+	mu        sync.Mutex
+	path      string
+	peers     map[string]string
+	addresses map[string]string
+	nicknames map[string]string
+	pending   map[string][]pin
+}
+
+func CertFingerprint(der []byte) string {
+	hash := sha256.Sum256(der)
+	return fmt.Sprintf("%x", hash)
+}
+
+// KeyFingerprint is the SHA-256 fingerprint of a PKIX public key, which is
+// what identifies a node.
+func KeyFingerprint(publicKey []byte) string {
+	hash := sha256.Sum256(publicKey)
+	return fmt.Sprintf("%x", hash)
+}
+
+func LoadKnownPeers(path string) (*KnownPeers, error) {
+	kp := &KnownPeers{path: path, peers: make(map[string]string), addresses: make(map[string]string), nicknames: make(map[string]string), pending: make(map[string][]pin)}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return kp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 3)
+		switch {
+		case len(fields) == 3 && fields[0] == bindAddress:
+			kp.addresses[fields[2]] = fields[1]
+		case len(fields) == 3 && fields[0] == bindNickname:
+			kp.nicknames[fields[2]] = fields[1]
+		case len(fields) == 2:
+			kp.peers[fields[0]] = fields[1]
+		default:
+			fmt.Println("Skipping malformed known peers entry:", line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	return kp, nil
+}
+
+func (kp *KnownPeers) save() error {
+	var sb strings.Builder
+	for identity, fingerprint := range kp.peers {
+		fmt.Fprintf(&sb, "%s %s\n", identity, fingerprint)
+	}
+	for address, identity := range kp.addresses {
+		fmt.Fprintf(&sb, "%s %s %s\n", bindAddress, identity, address)
+	}
+	for nickname, identity := range kp.nicknames {
+		fmt.Fprintf(&sb, "%s %s %s\n", bindNickname, identity, nickname)
+	}
+
+	return os.WriteFile(kp.path, []byte(sb.String()), 0600)
+}
+
+// Check pins fingerprint for identity if the peer is new, and fails if a
+// different fingerprint is already pinned.
+func (kp *KnownPeers) Check(identity, fingerprint string) error {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	pinned, exists := kp.peers[identity]
+	if !exists {
+		kp.peers[identity] = fingerprint
+		fmt.Printf("Pinned new peer %s with fingerprint %s\n", identity, fingerprint)
+		return kp.save()
+	}
+
+	if pinned != fingerprint {
+		kp.hold(identity, pin{pinCertificate, fingerprint})
+		return &PeerChangedError{Identity: identity, Pinned: pinned, Received: fingerprint}
+	}
+
+	return nil
+}
+
+func (kp *KnownPeers) binding(kind string) map[string]string {
+	if kind == bindAddress {
+		return kp.addresses
+	}
+	return kp.nicknames
+}
+
+// Bind ties address and nickname to identity the first time each is seen.
+// If either is already tied to another identity nothing is bound, the
+// change is held until Accept and a BindingChangedError is returned.
+func (kp *KnownPeers) Bind(identity, address, nickname string) error {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	wanted := []pin{{bindAddress, address}, {bindNickname, strings.TrimSpace(nickname)}}
+
+	var changed error
+	for _, p := range wanted {
+		if pinned, exists := kp.binding(p.kind)[p.value]; exists && pinned != identity && changed == nil {
+			changed = &BindingChangedError{Binding: p.kind, Value: p.value, Pinned: pinned, Received: identity}
+		}
+	}
+	if changed != nil {
+		kp.hold(identity, wanted...)
+		return changed
+	}
+
+	added := false
+	for _, p := range wanted {
+		if _, exists := kp.binding(p.kind)[p.value]; !exists && p.value != "" {
+			kp.binding(p.kind)[p.value] = identity
+			added = true
+		}
+	}
+	if !added {
+		return nil
+	}
+	return kp.save()
+}
+
+// hold records changes for identity that wait for the user.
+func (kp *KnownPeers) hold(identity string, pins ...pin) {
+	for _, p := range pins {
+		held := false
+		for _, existing := range kp.pending[identity] {
+			held = held || existing == p
+		}
+		if !held {
+			kp.pending[identity] = append(kp.pending[identity], p)
+		}
+	}
+}
+
+// Pending returns the identities with changes waiting to be accepted.
+func (kp *KnownPeers) Pending() []string {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	identities := make([]string, 0, len(kp.pending))
+	for identity := range kp.pending {
+		identities = append(identities, identity)
+	}
+	return identities
+}
+
+// Accept applies the changes held for identity: its new certificate, or the
+// addresses and nicknames it was refused under.
+func (kp *KnownPeers) Accept(identity string) error {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	pins, exists := kp.pending[identity]
+	if !exists {
+		return fmt.Errorf("no pending change for %s", identity)
+	}
+	delete(kp.pending, identity)
+
+	for _, p := range pins {
+		switch {
+		case p.kind == pinCertificate:
+			kp.peers[identity] = p.value
+		case p.value != "":
+			kp.binding(p.kind)[p.value] = identity
+		}
+	}
+	return kp.save()
+}
+
+// Warn prints the warning for a refused certificate or binding change.
+func Warn(err error) {
+	var certChanged *PeerChangedError
+	var bindingChanged *BindingChangedError
+
+	fmt.Println("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+	switch {
+	case errors.As(err, &certChanged):
+		fmt.Println("@       WARNING: PEER CERTIFICATE HAS CHANGED!             @")
+		fmt.Println("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+		fmt.Println("Someone could be impersonating", certChanged.Identity)
+		fmt.Println("Pinned fingerprint:  ", certChanged.Pinned)
+		fmt.Println("Received fingerprint:", certChanged.Received)
+		fmt.Printf("The connection was refused. If the change is expected, run /accept-peer %s\n", certChanged.Identity)
+	case errors.As(err, &bindingChanged):
+		fmt.Println("@       WARNING: PEER IDENTITY HAS CHANGED!                @")
+		fmt.Println("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+		fmt.Printf("Someone could be impersonating the node at %s %s\n", bindingChanged.Binding, bindingChanged.Value)
+		fmt.Println("Pinned identity:  ", bindingChanged.Pinned)
+		fmt.Println("Received identity:", bindingChanged.Received)
+		fmt.Printf("The node was refused. If the change is expected, run /accept-peer %s\n", bindingChanged.Received)
+	}
+}
+
+// VerifyPeer returns a tls.Config VerifyPeerCertificate callback that pins
+// the peer's certificate under the fingerprint of its public key. It is used
+// on accepted and dialed connections alike.
+func (kp *KnownPeers) VerifyPeer() func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("peer did not present a certificate")
+		}
+
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("invalid peer certificate: %v", err)
+		}
+
+		identity := KeyFingerprint(cert.RawSubjectPublicKeyInfo)
+		err = kp.Check(identity, CertFingerprint(rawCerts[0]))
+
+		// Renewals are only re-pinned when the peer announces them over a
+		// verified connection; any other change waits for the user.
+		var changed *PeerChangedError
+		if errors.As(err, &changed) {
+			Warn(changed)
+		}
+
+		return err
+	}
+}
+
+// Update replaces the fingerprint pinned for identity, used when a peer
+// announces a renewed certificate over an already verified connection.
+func (kp *KnownPeers) Update(identity, fingerprint string) error {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	if kp.peers[identity] == fingerprint {
+		return nil
+	}
+
+	kp.peers[identity] = fingerprint
+	fmt.Printf("Updated pinned certificate for %s to %s\n", identity, fingerprint)
+	return kp.save()
+}
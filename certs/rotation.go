@@ -0,0 +1,61 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RenewalWindow is how long before expiry a node certificate is renewed.
+const RenewalWindow = 30 * 24 * time.Hour
+
+var ErrCARenewalRequired = errors.New("certificate is issued by the network CA, request a new one with netca")
+
+func NeedsRenewal(cert *x509.Certificate, window time.Duration) bool {
+	return time.Now().Add(window).After(cert.NotAfter)
+}
+
+// RenewCert reissues the node certificate in dir for the existing key, so the
+// node keeps its identity. Nodes in a CA network can only renew themselves if
+// an unencrypted CA key is present in dir; otherwise ErrCARenewalRequired is
+// returned.
+func RenewCert(dir string, key crypto.Signer, host, port string) ([]byte, error) {
+	var der []byte
+
+	_, caErr := os.Stat(filepath.Join(dir, CAFile))
+	if caErr == nil {
+		caCert, caKey, err := LoadCA(dir, func() ([]byte, error) { return nil, ErrCARenewalRequired })
+		if err != nil {
+			return nil, ErrCARenewalRequired
+		}
+
+		tempCert, err := nodeTemplate(key, host, port)
+		if err != nil {
+			return nil, err
+		}
+
+		der, err = x509.CreateCertificate(rand.Reader, tempCert, caCert, key.Public(), caKey)
+		if err != nil {
+			return nil, fmt.Errorf("error signing node cert: %v", err)
+		}
+	} else {
+		var err error
+		der, err = selfSignedCert(key, host, port)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("error writing cert: %v", err)
+	}
+
+	return certPEM, nil
+}
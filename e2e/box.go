@@ -0,0 +1,117 @@
+package e2e
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const boxInfo = "go-p2p sealed box v1"
+
+// Envelope is a message sealed to a recipient's X25519 key with an ephemeral
+// sender key and ChaCha20-Poly1305. Only the recipient's private key opens it.
+type Envelope struct {
+	EphemeralKey []byte `json:"ephemeralKey"`
+	Nonce        []byte `json:"nonce"`
+	Ciphertext   []byte `json:"ciphertext"`
+}
+
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
+
+// DeriveKey expands an X25519 shared secret into a 32 byte AEAD key bound to
+// both public keys.
+func DeriveKey(secret, salt []byte, info string) ([]byte, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext to recipientKey. aad is authenticated but not
+// encrypted and must be given again to Open.
+func Seal(recipientKey, plaintext, aad []byte) (Envelope, error) {
+	recipient, err := ecdh.X25519().NewPublicKey(recipientKey)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("invalid recipient key: %v", err)
+	}
+
+	ephemeral, err := GenerateKey()
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	secret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	key, err := DeriveKey(secret, concat(ephemeral.PublicKey().Bytes(), recipientKey), boxInfo)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		EphemeralKey: ephemeral.PublicKey().Bytes(),
+		Nonce:        nonce,
+		Ciphertext:   aead.Seal(nil, nonce, plaintext, aad),
+	}, nil
+}
+
+func Open(priv *ecdh.PrivateKey, env Envelope, aad []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().NewPublicKey(env.EphemeralKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %v", err)
+	}
+
+	secret, err := priv.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := DeriveKey(secret, concat(env.EphemeralKey, priv.PublicKey().Bytes()), boxInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, aad)
+	if err != nil {
+		return nil, errors.New("message could not be decrypted")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,29 @@
+package e2e
+
+import "testing"
+
+func TestSealOpen(t *testing.T) {
+	recipient, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := Seal(recipient.PublicKey().Bytes(), []byte("secret"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Open(recipient, env, []byte("aad"))
+	if err != nil || string(got) != "secret" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	if _, err := Open(recipient, env, []byte("other")); err == nil {
+		t.Fatal("envelope opened with the wrong associated data")
+	}
+
+	other, _ := GenerateKey()
+	if _, err := Open(other, env, []byte("aad")); err == nil {
+		t.Fatal("envelope opened by someone else")
+	}
+}
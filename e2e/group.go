@@ -0,0 +1,64 @@
+package e2e
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// GroupMessage is content encrypted with a key shared by every member of a
+// channel. Epoch identifies which generation of the channel key was used.
+type GroupMessage struct {
+	Epoch      uint64 `json:"epoch"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func NewGroupKey() ([]byte, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func groupAAD(epoch uint64, aad []byte) []byte {
+	return binary.BigEndian.AppendUint64(aad[:len(aad):len(aad)], epoch)
+}
+
+func SealGroup(key []byte, epoch uint64, plaintext, aad []byte) (GroupMessage, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return GroupMessage{}, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return GroupMessage{}, err
+	}
+
+	return GroupMessage{
+		Epoch:      epoch,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, groupAAD(epoch, aad)),
+	}, nil
+}
+
+func OpenGroup(key []byte, msg GroupMessage, aad []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(msg.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+
+	plaintext, err := aead.Open(nil, msg.Nonce, msg.Ciphertext, groupAAD(msg.Epoch, aad))
+	if err != nil {
+		return nil, errors.New("message could not be decrypted")
+	}
+	return plaintext, nil
+}
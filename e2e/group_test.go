@@ -0,0 +1,35 @@
+package e2e
+
+import "testing"
+
+func TestGroupRoundTrip(t *testing.T) {
+	key, err := NewGroupKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := SealGroup(key, 3, []byte("to the channel"), []byte("#team"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := OpenGroup(key, msg, []byte("#team"))
+	if err != nil || string(got) != "to the channel" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	// The epoch and channel are authenticated.
+	moved := msg
+	moved.Epoch = 4
+	if _, err := OpenGroup(key, moved, []byte("#team")); err == nil {
+		t.Fatal("message opened under another epoch")
+	}
+	if _, err := OpenGroup(key, msg, []byte("#other")); err == nil {
+		t.Fatal("message opened for another channel")
+	}
+
+	rotated, _ := NewGroupKey()
+	if _, err := OpenGroup(rotated, msg, []byte("#team")); err == nil {
+		t.Fatal("message opened with a rotated key")
+	}
+}
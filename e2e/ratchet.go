@@ -0,0 +1,348 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// maxSkip bounds how many message keys are derived ahead for messages
+	// that arrive out of order.
+	maxSkip = 1000
+
+	initInfo    = "go-p2p ratchet init"
+	ratchetInfo = "go-p2p ratchet"
+	messageInfo = "go-p2p message keys"
+)
+
+var ErrNoSendingChain = errors.New("session cannot send until the peer has written to it")
+
+// Header is sent in the clear with every ratchet message. Init carries the
+// initiator's ephemeral key until the peer has replied, so the responder can
+// set up the session from whichever message reaches it first.
+type Header struct {
+	DH   []byte `json:"dh"`
+	PN   uint32 `json:"pn"`
+	N    uint32 `json:"n"`
+	Init []byte `json:"init,omitempty"`
+}
+
+type RatchetMessage struct {
+	Header     Header `json:"header"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Session is one side of a double ratchet conversation with a peer. It gives
+// forward secrecy: every message uses a fresh key and old keys are deleted.
+type Session struct {
+	PeerKey      []byte            `json:"peerKey"`
+	Init         []byte            `json:"init"`
+	Initiator    bool              `json:"initiator"`
+	Acknowledged bool              `json:"acknowledged"`
+	DHs          []byte            `json:"dhs"`
+	DHr          []byte            `json:"dhr"`
+	RK           []byte            `json:"rk"`
+	CKs          []byte            `json:"cks"`
+	CKr          []byte            `json:"ckr"`
+	Ns           uint32            `json:"ns"`
+	Nr           uint32            `json:"nr"`
+	PN           uint32            `json:"pn"`
+	Skipped      map[string][]byte `json:"skipped"`
+}
+
+func x25519(priv []byte, pub []byte) ([]byte, error) {
+	key, err := ecdh.X25519().NewPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := ecdh.X25519().NewPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.ECDH(peer)
+}
+
+func hkdfExpand(secret, salt []byte, info string, size int) ([]byte, error) {
+	out := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func kdfRK(rk, dhOut []byte) ([]byte, []byte, error) {
+	out, err := hkdfExpand(dhOut, rk, ratchetInfo, 64)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out[:32], out[32:], nil
+}
+
+func kdfCK(ck []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write([]byte{0x02})
+	next := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, ck)
+	mac.Write([]byte{0x01})
+	return next, mac.Sum(nil)
+}
+
+func messageCipher(mk []byte) (key, nonce []byte, err error) {
+	out, err := hkdfExpand(mk, nil, messageInfo, chacha20poly1305.KeySize+chacha20poly1305.NonceSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out[:chacha20poly1305.KeySize], out[chacha20poly1305.KeySize:], nil
+}
+
+// initialSecret mixes a static-static and an ephemeral-static exchange, so the
+// session is bound to both parties' long-term keys.
+func initialSecret(staticDH, ephemeralDH, init, responderKey []byte) ([]byte, error) {
+	return hkdfExpand(concat(staticDH, ephemeralDH), concat(init, responderKey), initInfo, 32)
+}
+
+// NewInitiatorSession starts a session towards a peer's static X25519 key.
+func NewInitiatorSession(ours *ecdh.PrivateKey, peerKey []byte) (*Session, error) {
+	ephemeral, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	staticDH, err := x25519(ours.Bytes(), peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralDH, err := x25519(ephemeral.Bytes(), peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	init := ephemeral.PublicKey().Bytes()
+	sk, err := initialSecret(staticDH, ephemeralDH, init, peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ratchetKey, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	dhOut, err := x25519(ratchetKey.Bytes(), peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rk, cks, err := kdfRK(sk, dhOut)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		PeerKey:   peerKey,
+		Init:      init,
+		Initiator: true,
+		DHs:       ratchetKey.Bytes(),
+		DHr:       peerKey,
+		RK:        rk,
+		CKs:       cks,
+		Skipped:   make(map[string][]byte),
+	}, nil
+}
+
+// NewResponderSession accepts a session a peer started with init.
+func NewResponderSession(ours *ecdh.PrivateKey, peerKey, init []byte) (*Session, error) {
+	staticDH, err := x25519(ours.Bytes(), peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralDH, err := x25519(ours.Bytes(), init)
+	if err != nil {
+		return nil, err
+	}
+
+	sk, err := initialSecret(staticDH, ephemeralDH, init, ours.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		PeerKey: peerKey,
+		Init:    init,
+		DHs:     ours.Bytes(),
+		RK:      sk,
+		Skipped: make(map[string][]byte),
+	}, nil
+}
+
+func (s *Session) clone() *Session {
+	c := *s
+	c.Skipped = make(map[string][]byte, len(s.Skipped))
+	for k, v := range s.Skipped {
+		c.Skipped[k] = v
+	}
+	return &c
+}
+
+func skippedKey(dh []byte, n uint32) string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(dh), n)
+}
+
+func headerAD(ad []byte, header Header) []byte {
+	data, _ := json.Marshal(header)
+	return concat(ad, data)
+}
+
+func (s *Session) Encrypt(plaintext, ad []byte) (RatchetMessage, error) {
+	if s.CKs == nil {
+		return RatchetMessage{}, ErrNoSendingChain
+	}
+
+	ours, err := ecdh.X25519().NewPrivateKey(s.DHs)
+	if err != nil {
+		return RatchetMessage{}, err
+	}
+
+	var mk []byte
+	s.CKs, mk = kdfCK(s.CKs)
+
+	header := Header{DH: ours.PublicKey().Bytes(), PN: s.PN, N: s.Ns}
+	if s.Initiator && !s.Acknowledged {
+		header.Init = s.Init
+	}
+	s.Ns++
+
+	key, nonce, err := messageCipher(mk)
+	if err != nil {
+		return RatchetMessage{}, err
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return RatchetMessage{}, err
+	}
+
+	return RatchetMessage{Header: header, Ciphertext: aead.Seal(nil, nonce, plaintext, headerAD(ad, header))}, nil
+}
+
+// Decrypt opens msg, advancing the ratchet. The session is only changed if
+// the message authenticates, so forged or corrupt messages leave it intact.
+func (s *Session) Decrypt(msg RatchetMessage, ad []byte) ([]byte, error) {
+	next := s.clone()
+
+	plaintext, err := next.decrypt(msg, ad)
+	if err != nil {
+		return nil, err
+	}
+
+	next.Acknowledged = true
+	*s = *next
+	return plaintext, nil
+}
+
+func (s *Session) decrypt(msg RatchetMessage, ad []byte) ([]byte, error) {
+	h := msg.Header
+
+	if mk, exists := s.Skipped[skippedKey(h.DH, h.N)]; exists {
+		delete(s.Skipped, skippedKey(h.DH, h.N))
+		return openWith(mk, msg, ad)
+	}
+
+	if !bytes.Equal(h.DH, s.DHr) {
+		if err := s.skipMessageKeys(h.PN); err != nil {
+			return nil, err
+		}
+		if err := s.dhRatchet(h); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.skipMessageKeys(h.N); err != nil {
+		return nil, err
+	}
+
+	var mk []byte
+	s.CKr, mk = kdfCK(s.CKr)
+	s.Nr++
+
+	return openWith(mk, msg, ad)
+}
+
+func (s *Session) skipMessageKeys(until uint32) error {
+	if s.CKr == nil {
+		return nil
+	}
+	if until > s.Nr+maxSkip {
+		return errors.New("too many skipped messages")
+	}
+
+	for s.Nr < until {
+		var mk []byte
+		s.CKr, mk = kdfCK(s.CKr)
+		s.Skipped[skippedKey(s.DHr, s.Nr)] = mk
+		s.Nr++
+	}
+	return nil
+}
+
+func (s *Session) dhRatchet(h Header) error {
+	s.PN = s.Ns
+	s.Ns = 0
+	s.Nr = 0
+	s.DHr = h.DH
+
+	dhOut, err := x25519(s.DHs, s.DHr)
+	if err != nil {
+		return err
+	}
+
+	if s.RK, s.CKr, err = kdfRK(s.RK, dhOut); err != nil {
+		return err
+	}
+
+	ratchetKey, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+	s.DHs = ratchetKey.Bytes()
+
+	if dhOut, err = x25519(s.DHs, s.DHr); err != nil {
+		return err
+	}
+
+	s.RK, s.CKs, err = kdfRK(s.RK, dhOut)
+	return err
+}
+
+func openWith(mk []byte, msg RatchetMessage, ad []byte) ([]byte, error) {
+	key, nonce, err := messageCipher(mk)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, msg.Ciphertext, headerAD(ad, msg.Header))
+	if err != nil {
+		return nil, errors.New("message could not be decrypted")
+	}
+	return plaintext, nil
+}
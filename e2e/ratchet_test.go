@@ -0,0 +1,162 @@
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+var testAD = []byte("alice:bob")
+
+// newPair returns an initiator session for alice and the responder session
+// bob sets up from alice's first message, which is decrypted on the way.
+func newPair(t *testing.T) (*Session, *Session) {
+	t.Helper()
+
+	aliceKey, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobKey, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, err := NewInitiatorSession(aliceKey, bobKey.PublicKey().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := alice.Encrypt([]byte("hello"), testAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := NewResponderSession(bobKey, aliceKey.PublicKey().Bytes(), first.Header.Init)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := bob.Decrypt(first, testAD); err != nil || string(got) != "hello" {
+		t.Fatalf("first message: got %q, %v", got, err)
+	}
+	return alice, bob
+}
+
+func mustEncrypt(t *testing.T, s *Session, text string) RatchetMessage {
+	t.Helper()
+	msg, err := s.Encrypt([]byte(text), testAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func expectPlaintext(t *testing.T, s *Session, msg RatchetMessage, want string) {
+	t.Helper()
+	got, err := s.Decrypt(msg, testAD)
+	if err != nil {
+		t.Fatalf("decrypting %q: %v", want, err)
+	}
+	if string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestRatchetRoundTrip(t *testing.T) {
+	alice, bob := newPair(t)
+
+	for i := 0; i < 3; i++ {
+		expectPlaintext(t, alice, mustEncrypt(t, bob, fmt.Sprintf("bob %d", i)), fmt.Sprintf("bob %d", i))
+		expectPlaintext(t, bob, mustEncrypt(t, alice, fmt.Sprintf("alice %d", i)), fmt.Sprintf("alice %d", i))
+	}
+
+	if !alice.Acknowledged {
+		t.Fatal("initiator session not acknowledged after a reply")
+	}
+	if msg := mustEncrypt(t, alice, "later"); msg.Header.Init != nil {
+		t.Fatal("acknowledged session still sends its init key")
+	}
+}
+
+func TestResponderCannotSendFirst(t *testing.T) {
+	aliceKey, _ := GenerateKey()
+	bobKey, _ := GenerateKey()
+
+	alice, err := NewInitiatorSession(aliceKey, bobKey.PublicKey().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := NewResponderSession(bobKey, aliceKey.PublicKey().Bytes(), alice.Init)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bob.Encrypt([]byte("too early"), testAD); err != ErrNoSendingChain {
+		t.Fatalf("got %v, want ErrNoSendingChain", err)
+	}
+}
+
+func TestRatchetOutOfOrder(t *testing.T) {
+	alice, bob := newPair(t)
+
+	var msgs []RatchetMessage
+	for i := 0; i < 4; i++ {
+		msgs = append(msgs, mustEncrypt(t, alice, fmt.Sprintf("m%d", i)))
+	}
+
+	for _, i := range []int{2, 0, 3, 1} {
+		expectPlaintext(t, bob, msgs[i], fmt.Sprintf("m%d", i))
+	}
+	if len(bob.Skipped) != 0 {
+		t.Fatalf("%d skipped keys left after every message arrived", len(bob.Skipped))
+	}
+}
+
+func TestRatchetSkippedKeysAcrossRatchet(t *testing.T) {
+	alice, bob := newPair(t)
+
+	// The first of alice's messages is delayed until after the ratchet has
+	// turned over twice.
+	delayed := mustEncrypt(t, alice, "delayed")
+	expectPlaintext(t, bob, mustEncrypt(t, alice, "on time"), "on time")
+	expectPlaintext(t, alice, mustEncrypt(t, bob, "reply"), "reply")
+	expectPlaintext(t, bob, mustEncrypt(t, alice, "next chain"), "next chain")
+
+	expectPlaintext(t, bob, delayed, "delayed")
+
+	// A skipped key is deleted once used, so a replay fails.
+	if _, err := bob.Decrypt(delayed, testAD); err == nil {
+		t.Fatal("replayed message decrypted twice")
+	}
+}
+
+func TestRatchetTooManySkipped(t *testing.T) {
+	alice, bob := newPair(t)
+
+	for i := 0; i <= maxSkip; i++ {
+		mustEncrypt(t, alice, "lost")
+	}
+	if _, err := bob.Decrypt(mustEncrypt(t, alice, "too far"), testAD); err == nil {
+		t.Fatal("message beyond the skip limit was accepted")
+	}
+}
+
+func TestRatchetRejectsTampering(t *testing.T) {
+	alice, bob := newPair(t)
+
+	msg := mustEncrypt(t, alice, "intact")
+	tampered := msg
+	tampered.Ciphertext = bytes.Clone(msg.Ciphertext)
+	tampered.Ciphertext[0] ^= 1
+
+	if _, err := bob.Decrypt(tampered, testAD); err == nil {
+		t.Fatal("tampered ciphertext decrypted")
+	}
+	if _, err := bob.Decrypt(msg, []byte("mallory:bob")); err == nil {
+		t.Fatal("message decrypted with the wrong associated data")
+	}
+
+	// Failed attempts leave the session as it was.
+	expectPlaintext(t, bob, msg, "intact")
+}
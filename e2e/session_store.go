@@ -0,0 +1,103 @@
+package e2e
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// SessionStore persists ratchet sessions, one file per peer. Files are
+// encrypted with a key derived from the node's X25519 key, which is itself
+// protected by the profile passphrase.
+type SessionStore struct {
+	dir string
+	key []byte
+}
+
+func NewSessionStore(dir string, static *ecdh.PrivateKey) (*SessionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating session directory: %v", err)
+	}
+
+	key, err := DeriveKey(static.Bytes(), nil, "go-p2p session storage")
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionStore{dir: dir, key: key}, nil
+}
+
+func (st *SessionStore) path(peerID string) string {
+	return filepath.Join(st.dir, filepath.Base(peerID)+".session")
+}
+
+// Load returns the stored session with peerID, or nil if there is none.
+func (st *SessionStore) Load(peerID string) (*Session, error) {
+	data, err := os.ReadFile(st.path(peerID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading session: %v", err)
+	}
+
+	aead, err := chacha20poly1305.New(st.key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("session file is corrupt")
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(peerID))
+	if err != nil {
+		return nil, errors.New("session file is corrupt")
+	}
+
+	var session Session
+	if err := json.Unmarshal(plaintext, &session); err != nil {
+		return nil, fmt.Errorf("error decoding session: %v", err)
+	}
+	if session.Skipped == nil {
+		session.Skipped = make(map[string][]byte)
+	}
+	return &session, nil
+}
+
+func (st *SessionStore) Save(peerID string, session *Session) error {
+	plaintext, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	aead, err := chacha20poly1305.New(st.key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	data := aead.Seal(nonce, nonce, plaintext, []byte(peerID))
+	if err := os.WriteFile(st.path(peerID), data, 0600); err != nil {
+		return fmt.Errorf("error writing session: %v", err)
+	}
+	return nil
+}
+
+func (st *SessionStore) Delete(peerID string) error {
+	err := os.Remove(st.path(peerID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
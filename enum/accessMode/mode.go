@@ -0,0 +1,9 @@
+package accessMode
+
+type Mode string
+
+const (
+	Public   Mode = "public"
+	Password Mode = "password"
+	Invite   Mode = "invite"
+)
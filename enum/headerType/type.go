@@ -10,4 +10,13 @@ const (
 	PrivateMessage Type = "PM"
 	Exit           Type = "EXIT"
 	ChatMessage    Type = "CHAT MESSAGE"
+	CertUpdate     Type = "CERT UPDATE"
+	ChannelKey     Type = "CHANNEL KEY"
+	Revocation     Type = "REVOCATION"
+	Moderation     Type = "MODERATION"
+	JoinRejected   Type = "JOIN REJECTED"
+	JoinAdmitted   Type = "JOIN ADMITTED"
+	Metadata       Type = "CHANNEL METADATA"
+	HistoryRequest Type = "HISTORY REQUEST"
+	HistoryPage    Type = "HISTORY PAGE"
 )
@@ -0,0 +1,11 @@
+package keyAlgorithm
+
+type Algorithm string
+
+const (
+	Ed25519   Algorithm = "ed25519"
+	ECDSAP256 Algorithm = "ecdsa-p256"
+	RSA2048   Algorithm = "rsa"
+)
+
+const Default = Ed25519
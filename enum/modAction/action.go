@@ -0,0 +1,12 @@
+package modAction
+
+type Action string
+
+const (
+	SetRole Action = "ROLE"
+	Kick    Action = "KICK"
+	Ban     Action = "BAN"
+	Unban   Action = "UNBAN"
+	Mute    Action = "MUTE"
+	Unmute  Action = "UNMUTE"
+)
@@ -0,0 +1,29 @@
+package role
+
+type Role string
+
+const (
+	Owner    Role = "owner"
+	Operator Role = "operator"
+	Voiced   Role = "voiced"
+	Member   Role = "member"
+)
+
+// Rank orders roles from Member (0) to Owner (3); unknown roles rank as
+// members.
+func (r Role) Rank() int {
+	switch r {
+	case Owner:
+		return 3
+	case Operator:
+		return 2
+	case Voiced:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (r Role) Valid() bool {
+	return r == Owner || r == Operator || r == Voiced || r == Member
+}
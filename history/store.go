@@ -0,0 +1,392 @@
+package history
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/e2e"
+	"go-p2p/model"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const logFile = "messages.log"
+
+var recordAAD = []byte("go-p2p history")
+
+// Entry is one stored message: a channel message names its channel and the
+// creation of the channel it was sent in, a private message the peer it was
+// exchanged with.
+type Entry struct {
+	Channel  string        `json:"channel,omitempty"`
+	Creation string        `json:"creation,omitempty"`
+	Peer     string        `json:"peer,omitempty"`
+	Message  model.Message `json:"message"`
+}
+
+// Store keeps channel and private message history in an append-only log.
+// Each record is length-prefixed and encrypted with a key derived from the
+// node's X25519 key, like the ratchet sessions. The log is read once when
+// the store is opened and indexed in memory by channel, peer, sender and
+// time.
+type Store struct {
+	mu        sync.Mutex
+	path      string
+	key       []byte
+	file      *os.File
+	entries   []Entry
+	seen      map[string]bool
+	byChannel map[string][]int
+	byPeer    map[string][]int
+	bySender  map[string][]int
+	// byTime holds entry indexes ordered by message timestamp.
+	byTime []int
+}
+
+func Open(dir string, static *ecdh.PrivateKey) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating history directory: %v", err)
+	}
+
+	key, err := e2e.DeriveKey(static.Bytes(), nil, "go-p2p history storage")
+	if err != nil {
+		return nil, err
+	}
+
+	st := &Store{path: filepath.Join(dir, logFile), key: key}
+	if err := st.load(); err != nil {
+		return nil, err
+	}
+
+	if err := st.open(); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// open opens the log for appending.
+func (st *Store) open() error {
+	file, err := os.OpenFile(st.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening history log: %v", err)
+	}
+	st.file = file
+	return nil
+}
+
+// load reads the log and builds the indexes. A record cut short by a crash
+// ends the log and is truncated; a record that does not decrypt is skipped.
+func (st *Store) load() error {
+	st.reset()
+
+	data, err := os.ReadFile(st.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading history log: %v", err)
+	}
+
+	aead, err := chacha20poly1305.New(st.key)
+	if err != nil {
+		return err
+	}
+
+	offset, skipped := 0, 0
+	for offset < len(data) {
+		if len(data)-offset < 4 {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(data[offset:]))
+		if size < aead.NonceSize() || len(data)-offset-4 < size {
+			break
+		}
+		record := data[offset+4 : offset+4+size]
+		offset += 4 + size
+
+		plaintext, err := aead.Open(nil, record[:aead.NonceSize()], record[aead.NonceSize():], recordAAD)
+		if err != nil {
+			skipped++
+			continue
+		}
+
+		var e Entry
+		if err := json.Unmarshal(plaintext, &e); err != nil {
+			skipped++
+			continue
+		}
+		st.index(e)
+	}
+
+	if skipped > 0 {
+		fmt.Printf("Skipped %d unreadable records in %s\n", skipped, st.path)
+	}
+	if offset < len(data) {
+		fmt.Println("Truncating incomplete record at the end of", st.path)
+		if err := os.Truncate(st.path, int64(offset)); err != nil {
+			return fmt.Errorf("error truncating history log: %v", err)
+		}
+	}
+	return nil
+}
+
+func (st *Store) reset() {
+	st.entries = nil
+	st.seen = make(map[string]bool)
+	st.byChannel = make(map[string][]int)
+	st.byPeer = make(map[string][]int)
+	st.bySender = make(map[string][]int)
+	st.byTime = nil
+}
+
+func entryKey(e Entry) string {
+	return e.Channel + "|" + e.Creation + "|" + e.Peer + "|" + e.Message.ID()
+}
+
+// index adds e to the indexes and reports false if it is already stored.
+func (st *Store) index(e Entry) bool {
+	if st.seen[entryKey(e)] {
+		return false
+	}
+	st.seen[entryKey(e)] = true
+
+	i := len(st.entries)
+	st.entries = append(st.entries, e)
+	if e.Channel != "" {
+		st.byChannel[e.Channel] = append(st.byChannel[e.Channel], i)
+	}
+	if e.Peer != "" {
+		st.byPeer[e.Peer] = append(st.byPeer[e.Peer], i)
+	}
+	st.bySender[e.Message.HashID] = append(st.bySender[e.Message.HashID], i)
+
+	// Messages mostly arrive in order, so this is usually an append.
+	at := sort.Search(len(st.byTime), func(j int) bool {
+		return st.entries[st.byTime[j]].Message.Timestamp.After(e.Message.Timestamp)
+	})
+	st.byTime = append(st.byTime, 0)
+	copy(st.byTime[at+1:], st.byTime[at:])
+	st.byTime[at] = i
+	return true
+}
+
+func (st *Store) encode(e Entry) ([]byte, error) {
+	plaintext, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.New(st.key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	record := aead.Seal(nonce, nonce, plaintext, recordAAD)
+	framed := make([]byte, 4, 4+len(record))
+	binary.BigEndian.PutUint32(framed, uint32(len(record)))
+	return append(framed, record...), nil
+}
+
+// Append stores e unless the same message is already stored.
+func (st *Store) Append(e Entry) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.seen[entryKey(e)] {
+		return nil
+	}
+
+	// A log that could not be reopened after Expire is retried here.
+	if st.file == nil {
+		if err := st.open(); err != nil {
+			return err
+		}
+	}
+
+	record, err := st.encode(e)
+	if err != nil {
+		return fmt.Errorf("error encoding history record: %v", err)
+	}
+	if _, err := st.file.Write(record); err != nil {
+		return fmt.Errorf("error writing history log: %v", err)
+	}
+
+	st.index(e)
+	return nil
+}
+
+func (st *Store) messages(indexes []int) []model.Message {
+	messages := make([]model.Message, 0, len(indexes))
+	for _, i := range indexes {
+		messages = append(messages, st.entries[i].Message)
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Timestamp.Before(messages[j].Timestamp) })
+	return messages
+}
+
+// Channel returns the stored messages of one creation of a channel, oldest
+// first.
+func (st *Store) Channel(name, creation string) []model.Message {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var indexes []int
+	for _, i := range st.byChannel[name] {
+		if st.entries[i].Creation == creation {
+			indexes = append(indexes, i)
+		}
+	}
+	return st.messages(indexes)
+}
+
+// ChannelNamed returns the stored messages of every channel called name,
+// oldest first.
+func (st *Store) ChannelNamed(name string) []Entry {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	entries := make([]Entry, 0, len(st.byChannel[name]))
+	for _, i := range st.byChannel[name] {
+		entries = append(entries, st.entries[i])
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Message.Timestamp.Before(entries[j].Message.Timestamp) })
+	return entries
+}
+
+// Conversation returns the private messages exchanged with peer, oldest
+// first.
+func (st *Store) Conversation(peer string) []model.Message {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.messages(st.byPeer[peer])
+}
+
+// Peers returns every peer we have private messages with.
+func (st *Store) Peers() []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	peers := make([]string, 0, len(st.byPeer))
+	for peer := range st.byPeer {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// From returns every stored message sent by sender, oldest first.
+func (st *Store) From(sender string) []Entry {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	entries := make([]Entry, 0, len(st.bySender[sender]))
+	for _, i := range st.bySender[sender] {
+		entries = append(entries, st.entries[i])
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Message.Timestamp.Before(entries[j].Message.Timestamp) })
+	return entries
+}
+
+// Between returns the stored messages sent from start up to end, oldest
+// first.
+func (st *Store) Between(start, end time.Time) []Entry {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	first := sort.Search(len(st.byTime), func(j int) bool {
+		return !st.entries[st.byTime[j]].Message.Timestamp.Before(start)
+	})
+
+	var entries []Entry
+	for _, i := range st.byTime[first:] {
+		if st.entries[i].Message.Timestamp.After(end) {
+			break
+		}
+		entries = append(entries, st.entries[i])
+	}
+	return entries
+}
+
+// Expire removes the messages of one creation of a channel sent before
+// cutoff. The log is rewritten only when there is something to remove.
+func (st *Store) Expire(channel, creation string, cutoff time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	expires := func(e Entry) bool {
+		return e.Channel == channel && e.Creation == creation && e.Message.Timestamp.Before(cutoff)
+	}
+
+	expired := false
+	for _, i := range st.byChannel[channel] {
+		if expires(st.entries[i]) {
+			expired = true
+			break
+		}
+	}
+	if !expired {
+		return nil
+	}
+
+	var kept []Entry
+	var log bytes.Buffer
+	for _, e := range st.entries {
+		if expires(e) {
+			continue
+		}
+		record, err := st.encode(e)
+		if err != nil {
+			return fmt.Errorf("error encoding history record: %v", err)
+		}
+		log.Write(record)
+		kept = append(kept, e)
+	}
+
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, log.Bytes(), 0600); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing history log: %v", err)
+	}
+	// The old log stays open for appending until the new one is in place.
+	if err := os.Rename(tmp, st.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error replacing history log: %v", err)
+	}
+
+	st.reset()
+	for _, e := range kept {
+		st.index(e)
+	}
+
+	if st.file != nil {
+		st.file.Close()
+		st.file = nil
+	}
+	return st.open()
+}
+
+func (st *Store) Close() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.file == nil {
+		return nil
+	}
+	err := st.file.Close()
+	st.file = nil
+	return err
+}
@@ -0,0 +1,150 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-p2p/e2e"
+	"go-p2p/model"
+)
+
+const testCreation = "creator@1"
+
+func testEntry(i int, at time.Time) Entry {
+	msg := model.Message{Content: fmt.Sprintf("message %d", i), HashID: "alice", Nonce: fmt.Sprint(i), Timestamp: at}
+	return Entry{Channel: "room", Creation: testCreation, Message: msg}
+}
+
+// openStore opens the store in dir, and reopen closes it and opens it again
+// with the same key, reading the log back from disk.
+func openStore(t *testing.T, dir string) (*Store, func(*Store) *Store) {
+	t.Helper()
+
+	key, err := e2e.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open := func() *Store {
+		st, err := Open(dir, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { st.Close() })
+		return st
+	}
+	reopen := func(st *Store) *Store {
+		if err := st.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return open()
+	}
+	return open(), reopen
+}
+
+func contents(messages []model.Message) []string {
+	var got []string
+	for _, msg := range messages {
+		got = append(got, msg.Content)
+	}
+	return got
+}
+
+func TestStoreReopen(t *testing.T) {
+	st, reopen := openStore(t, t.TempDir())
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := st.Append(testEntry(i, start.Add(time.Duration(i)*time.Second))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Storing a message again is a no-op.
+	if err := st.Append(testEntry(1, start.Add(time.Second))); err != nil {
+		t.Fatal(err)
+	}
+
+	st = reopen(st)
+	got := contents(st.Channel("room", testCreation))
+	if fmt.Sprint(got) != "[message 0 message 1 message 2]" {
+		t.Fatalf("reopened store holds %q", got)
+	}
+	if other := st.Channel("room", "creator@2"); len(other) != 0 {
+		t.Fatalf("another creation of the channel holds %d messages", len(other))
+	}
+}
+
+func TestStoreTruncatesTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	st, reopen := openStore(t, dir)
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := st.Append(testEntry(i, start.Add(time.Duration(i)*time.Second))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dir, logFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filepath.Join(dir, logFile), info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	st = reopen(st)
+	if got := contents(st.Channel("room", testCreation)); fmt.Sprint(got) != "[message 0]" {
+		t.Fatalf("store with a torn record holds %q", got)
+	}
+
+	// The torn record is gone, so new records follow the last whole one.
+	if err := st.Append(testEntry(2, start.Add(2*time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	st = reopen(st)
+	if got := contents(st.Channel("room", testCreation)); fmt.Sprint(got) != "[message 0 message 2]" {
+		t.Fatalf("store after appending holds %q", got)
+	}
+}
+
+func TestStoreExpire(t *testing.T) {
+	dir := t.TempDir()
+	st, reopen := openStore(t, dir)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := st.Append(testEntry(i, start.Add(time.Duration(i)*time.Second))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	private := Entry{Peer: "bob", Message: model.Message{Content: "private", HashID: "bob", Nonce: "p", Timestamp: start}}
+	if err := st.Append(private); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := st.Expire("room", testCreation, start.Add(1500*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(st.Channel("room", testCreation)); fmt.Sprint(got) != "[message 2]" {
+		t.Fatalf("expired store holds %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, logFile+".tmp")); !os.IsNotExist(err) {
+		t.Fatalf("temporary log left behind: %v", err)
+	}
+
+	// The rewritten log is the one appended to and read back.
+	if err := st.Append(testEntry(3, start.Add(3*time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	st = reopen(st)
+	if got := contents(st.Channel("room", testCreation)); fmt.Sprint(got) != "[message 2 message 3]" {
+		t.Fatalf("reopened expired store holds %q", got)
+	}
+	if got := contents(st.Conversation("bob")); fmt.Sprint(got) != "[private]" {
+		t.Fatalf("expiring a channel changed private history: %q", got)
+	}
+}
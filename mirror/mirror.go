@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"go-p2p/model"
 	"net/http"
@@ -9,47 +11,91 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func newServer(nodes *[]model.Node) model.DiscoverMessage {
+func newServer(nodes []model.Node, nickname string, revocations []model.Revocation) model.DiscoverMessage {
 
-	fmt.Println("Sending connection list: ", *nodes)
+	fmt.Println("Sending connection list: ", nodes)
 
-	msg := model.DiscoverMessage{NodeList: *nodes, Timestamp: time.Now()}
+	msg := model.DiscoverMessage{NodeList: nodes, Nickname: nickname, Revocations: revocations, Timestamp: time.Now()}
 
 	return msg
 }
 
-func addServer(incomingNode model.Node, nodes *[]model.Node, nnMap *map[string]int) {
-	nickname := incomingNode.Nickname
-
-	if _, ok := (*nnMap)[incomingNode.Nickname]; ok {
-		(*nnMap)[nickname]++
-		nickname = fmt.Sprintf("%s(%d)", nickname, (*nnMap)[nickname])
-	} else {
-		(*nnMap)[nickname] = 0
+func registrationStatus(err error) int {
+	if errors.Is(err, errNicknameTaken) || errors.Is(err, errNotOwner) {
+		return http.StatusConflict
 	}
-
-	newNode := model.Node{Hostname: incomingNode.Hostname, Port: incomingNode.Port, Nickname: nickname}
-	*nodes = append(*nodes, newNode)
-	fmt.Println("New node connected:", newNode)
+	return http.StatusForbidden
 }
 
 func main() {
+	registryPath := flag.String("registry", "nicknames.json", "file the nickname registry is stored in")
+	releaseAfter := flag.Duration("release-after", 30*24*time.Hour, "inactivity period after which a nickname is released")
+	strict := flag.Bool("strict-nicknames", false, "reject registrations for nicknames owned by another key instead of disambiguating them")
+	revocationsPath := flag.String("revocations", "revocations.json", "file revoked keys are stored in")
+	flag.Parse()
+
 	fmt.Println("Starting mirror...")
 
+	revocations, err := model.LoadRevocationList(*revocationsPath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	r := gin.Default()
 
-	var connectedNodes []model.Node
-	nicknameMap := make(map[string]int)
+	nicknames := newRegistry(*registryPath, *releaseAfter, *strict, revocations)
 
 	r.POST("/getNodes", func(c *gin.Context) {
-		var node model.Node
-		if err := c.BindJSON(&node); err != nil {
+		var reg model.Registration
+		if err := c.BindJSON(&reg); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		nickname, err := nicknames.claim(reg)
+		if err != nil {
+			fmt.Println("Registration rejected:", err)
+			c.JSON(registrationStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, newServer(nicknames.nodeList(), nickname, revocations.All()))
+	})
+
+	r.POST("/revoke", func(c *gin.Context) {
+		var rev model.Revocation
+		if err := c.BindJSON(&rev); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		addServer(node, &connectedNodes, &nicknameMap)
-		c.JSON(http.StatusOK, newServer(&connectedNodes))
+		added, err := nicknames.revoke(rev)
+		if err != nil {
+			fmt.Println("Revocation rejected:", err)
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		if added {
+			fmt.Println("Revoked key", rev.Identity())
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	r.POST("/renew", func(c *gin.Context) {
+		var reg model.Registration
+		if err := c.BindJSON(&reg); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := nicknames.renew(reg); err != nil {
+			c.JSON(registrationStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
 	})
 
 	r.Run(":8080")
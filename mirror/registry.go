@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/model"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errNicknameTaken = errors.New("nickname is registered to another key")
+var errNotOwner = errors.New("nickname is not registered to this key")
+var errRevoked = errors.New("key has been revoked")
+
+// Registrations older than this are treated as replays.
+const registrationWindow = 5 * time.Minute
+
+type nicknameOwner struct {
+	Nickname   string    `json:"nickname"`
+	KeyID      string    `json:"keyId"`
+	PublicKey  []byte    `json:"publicKey"`
+	Registered time.Time `json:"registered"`
+	LastSeen   time.Time `json:"lastSeen"`
+}
+
+// registry binds nicknames to the public key that first claimed them. A
+// nickname is released once its owner has not renewed it for releaseAfter.
+type registry struct {
+	mu           sync.Mutex
+	path         string
+	releaseAfter time.Duration
+	strict       bool
+	owners       map[string]*nicknameOwner
+	nodes        map[string]model.Node
+	revocations  *model.RevocationList
+}
+
+func newRegistry(path string, releaseAfter time.Duration, strict bool, revocations *model.RevocationList) *registry {
+	r := &registry{
+		path:         path,
+		releaseAfter: releaseAfter,
+		strict:       strict,
+		owners:       make(map[string]*nicknameOwner),
+		nodes:        make(map[string]model.Node),
+		revocations:  revocations,
+	}
+	r.load()
+	return r
+}
+
+func (r *registry) load() {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("Error reading nickname registry:", err)
+		}
+		return
+	}
+
+	var owners []*nicknameOwner
+	if err := json.Unmarshal(data, &owners); err != nil {
+		fmt.Println("Error decoding nickname registry:", err)
+		return
+	}
+
+	for _, owner := range owners {
+		r.owners[owner.Nickname] = owner
+	}
+}
+
+func (r *registry) save() {
+	owners := make([]*nicknameOwner, 0, len(r.owners))
+	for _, owner := range r.owners {
+		owners = append(owners, owner)
+	}
+
+	data, err := json.MarshalIndent(owners, "", "  ")
+	if err != nil {
+		fmt.Println("Error encoding nickname registry:", err)
+		return
+	}
+
+	if err := os.WriteFile(r.path, data, 0600); err != nil {
+		fmt.Println("Error writing nickname registry:", err)
+	}
+}
+
+func (r *registry) releaseExpired(now time.Time) {
+	for nickname, owner := range r.owners {
+		if now.Sub(owner.LastSeen) > r.releaseAfter {
+			fmt.Println("Releasing inactive nickname:", nickname)
+			delete(r.owners, nickname)
+			r.forgetNode(owner.KeyID)
+		}
+	}
+}
+
+// forgetNode drops keyID from the node list once it owns no nickname.
+func (r *registry) forgetNode(keyID string) {
+	for _, owner := range r.owners {
+		if owner.KeyID == keyID {
+			return
+		}
+	}
+	delete(r.nodes, keyID)
+}
+
+func verifyRegistration(reg model.Registration, now time.Time) error {
+	if err := reg.Verify(); err != nil {
+		return err
+	}
+
+	if age := now.Sub(reg.Timestamp); age > registrationWindow || age < -registrationWindow {
+		return errors.New("registration timestamp outside accepted window")
+	}
+
+	return nil
+}
+
+// claim registers or renews the nickname in reg and returns the nickname the
+// node should use. When the nickname belongs to another key the caller either
+// gets errNicknameTaken (strict mode) or a name suffixed with its own key
+// fingerprint, which is the same every time that key asks.
+func (r *registry) claim(reg model.Registration) (string, error) {
+	now := time.Now()
+	if err := verifyRegistration(reg, now); err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.releaseExpired(now)
+
+	keyID := model.KeyFingerprint(reg.Node.PublicKey)
+	if r.revocations.Revoked(keyID) {
+		return "", errRevoked
+	}
+
+	nickname := strings.TrimSpace(reg.Node.Nickname)
+
+	if owner, exists := r.owners[nickname]; exists && owner.KeyID != keyID {
+		if r.strict {
+			return "", errNicknameTaken
+		}
+
+		nickname = fmt.Sprintf("%s#%s", nickname, keyID[:8])
+		if owner, exists := r.owners[nickname]; exists && owner.KeyID != keyID {
+			return "", errNicknameTaken
+		}
+	}
+
+	if owner, exists := r.owners[nickname]; exists {
+		owner.LastSeen = now
+	} else {
+		r.owners[nickname] = &nicknameOwner{Nickname: nickname, KeyID: keyID, PublicKey: reg.Node.PublicKey, Registered: now, LastSeen: now}
+		fmt.Println("Registered nickname", nickname, "to key", keyID)
+	}
+	r.save()
+
+	r.nodes[keyID] = model.Node{Hostname: reg.Node.Hostname, Port: reg.Node.Port, Nickname: nickname, PublicKey: reg.Node.PublicKey, EncryptionKey: reg.Node.EncryptionKey, EncryptionKeySig: reg.Node.EncryptionKeySig}
+	return nickname, nil
+}
+
+// renew refreshes the inactivity timer of a nickname already owned by the
+// signing key without changing the node list.
+func (r *registry) renew(reg model.Registration) error {
+	now := time.Now()
+	if err := verifyRegistration(reg, now); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.releaseExpired(now)
+
+	owner, exists := r.owners[strings.TrimSpace(reg.Node.Nickname)]
+	if !exists || owner.KeyID != model.KeyFingerprint(reg.Node.PublicKey) {
+		return errNotOwner
+	}
+
+	owner.LastSeen = now
+	r.save()
+	return nil
+}
+
+// revoke records a revocation, drops the revoked node from the node list and
+// releases its nicknames. It reports whether the revocation was new.
+func (r *registry) revoke(rev model.Revocation) (bool, error) {
+	added, err := r.revocations.Add(rev)
+	if !added {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keyID := rev.Identity()
+	delete(r.nodes, keyID)
+	for nickname, owner := range r.owners {
+		if owner.KeyID == keyID {
+			fmt.Println("Releasing nickname of revoked key:", nickname)
+			delete(r.owners, nickname)
+		}
+	}
+	r.save()
+
+	return true, err
+}
+
+func (r *registry) nodeList() []model.Node {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	nodes := make([]model.Node, 0, len(r.nodes))
+	for _, node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
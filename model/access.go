@@ -0,0 +1,167 @@
+package model
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/certs"
+	"go-p2p/enum/accessMode"
+	"go-p2p/enum/role"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Invite lets a node join an invite-only channel. It is signed by an
+// operator of the channel, may be bound to one invitee and may expire.
+type Invite struct {
+	Channel    string    `json:"channel"`
+	Invitee    string    `json:"invitee,omitempty"`
+	Inviter    string    `json:"inviter"`
+	InviterKey []byte    `json:"inviterKey"`
+	Expires    time.Time `json:"expires,omitempty"`
+	Signature  []byte    `json:"signature,omitempty"`
+}
+
+func (inv Invite) Digest() []byte {
+	inv.Signature = nil
+	inv.Expires = inv.Expires.UTC()
+
+	data, _ := json.Marshal(inv)
+	hash := sha256.Sum256(append([]byte("go-p2p invite:"), data...))
+	return hash[:]
+}
+
+func (inv *Invite) Sign(key crypto.Signer, publicKey []byte) error {
+	inv.Inviter = KeyFingerprint(publicKey)
+	inv.InviterKey = publicKey
+
+	signature, err := certs.Sign(key, inv.Digest())
+	if err != nil {
+		return fmt.Errorf("error signing invite: %v", err)
+	}
+
+	inv.Signature = signature
+	return nil
+}
+
+func (inv Invite) Verify() error {
+	if len(inv.InviterKey) == 0 || len(inv.Signature) == 0 {
+		return errors.New("invite is not signed")
+	}
+
+	if KeyFingerprint(inv.InviterKey) != inv.Inviter {
+		return errors.New("invite key does not match its inviter")
+	}
+
+	if err := certs.VerifyPKIX(inv.InviterKey, inv.Digest(), inv.Signature); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	return nil
+}
+
+// Token encodes the invite so it can be passed on as a single word.
+func (inv Invite) Token() string {
+	data, _ := json.Marshal(inv)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func ParseInvite(token string) (Invite, error) {
+	var inv Invite
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return inv, errors.New("malformed invite token")
+	}
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return inv, errors.New("malformed invite token")
+	}
+	return inv, nil
+}
+
+// JoinRequest is the content of an UpdateChannel message. Proof and Invite
+// are only needed for password and invite-only channels.
+type JoinRequest struct {
+	Channel string  `json:"channel"`
+	Proof   []byte  `json:"proof,omitempty"`
+	Invite  *Invite `json:"invite,omitempty"`
+}
+
+// JoinRejection tells a node why it was not let into a channel. A position
+// means it was put on the channel's waitlist instead.
+type JoinRejection struct {
+	Channel  string `json:"channel"`
+	Reason   string `json:"reason"`
+	Position int    `json:"position,omitempty"`
+}
+
+// JoinAdmission tells the other members of a channel that its coordinator
+// let a node in, so every member admits the same nodes.
+type JoinAdmission struct {
+	Channel string `json:"channel"`
+	Member  string `json:"member"`
+}
+
+// PasswordVerifier derives the secret members of a password channel check
+// joins against. The password itself never leaves the node it was typed on.
+func PasswordVerifier(channel, creator, password string) ([]byte, error) {
+	return scrypt.Key([]byte(password), []byte("go-p2p channel:"+channel+":"+creator), 1<<15, 8, 1, 32)
+}
+
+// JoinProof shows knowledge of a channel's verifier, bound to the joining
+// identity so it cannot be reused by anyone else.
+func JoinProof(verifier []byte, channel, joiner string) []byte {
+	mac := hmac.New(sha256.New, verifier)
+	mac.Write([]byte("join:" + channel + ":" + joiner))
+	return mac.Sum(nil)
+}
+
+// CheckJoin decides whether joiner may enter the channel with req.
+func (c *Channel) CheckJoin(req JoinRequest, joiner string) error {
+	if c.Banned[joiner] {
+		return errors.New("banned from the channel")
+	}
+
+	switch c.Metadata.Settings.Access {
+	case accessMode.Password:
+		if c.PasswordVerifier == nil {
+			return errors.New("this node does not know the channel password")
+		}
+		if !hmac.Equal(req.Proof, JoinProof(c.PasswordVerifier, c.ChannelName, joiner)) {
+			return errors.New("wrong channel password")
+		}
+	case accessMode.Invite:
+		// Operators could invite themselves, so they need no invite.
+		if c.RoleOf(joiner).Rank() >= role.Operator.Rank() {
+			return nil
+		}
+		if req.Invite == nil {
+			return errors.New("channel is invite-only")
+		}
+		return c.CheckInvite(*req.Invite, joiner)
+	}
+	return nil
+}
+
+func (c *Channel) CheckInvite(inv Invite, joiner string) error {
+	if inv.Channel != c.ChannelName {
+		return fmt.Errorf("invite is for channel %s", inv.Channel)
+	}
+	if err := inv.Verify(); err != nil {
+		return err
+	}
+	if c.RoleOf(inv.Inviter).Rank() < role.Operator.Rank() {
+		return errors.New("invite was not issued by a channel operator")
+	}
+	if !inv.Expires.IsZero() && time.Now().After(inv.Expires) {
+		return errors.New("invite has expired")
+	}
+	if inv.Invitee != "" && inv.Invitee != joiner {
+		return errors.New("invite was issued to someone else")
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Attachment is a small file sent inline in a chat message. The message
+// content holds it encoded as JSON, so encrypted channels seal it like text.
+type Attachment struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+func (a Attachment) Encode() string {
+	data, _ := json.Marshal(a)
+	return string(data)
+}
+
+func DecodeAttachment(content string) (Attachment, error) {
+	var a Attachment
+	if err := json.Unmarshal([]byte(content), &a); err != nil {
+		return a, fmt.Errorf("malformed attachment: %v", err)
+	}
+	return a, nil
+}
+
+func (a Attachment) String() string {
+	return fmt.Sprintf("[attachment %s, %d bytes]", a.Name, len(a.Data))
+}
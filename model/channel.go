@@ -2,14 +2,40 @@ package model
 
 import (
 	"fmt"
+	"go-p2p/enum/role"
 	"sort"
+	"strings"
 )
 
 type Channel struct {
 	ConnectedNodes map[string]Node `json:"connectedNodes"`
 	ChatHistory    []Message       `json:"chatHistory"`
 	ChannelName    string          `json:"channelName"`
-	ConnLimit      int             `json:"-"`
+	// Metadata names the creator, who owns the channel, and carries its
+	// settings, topic and description.
+	Metadata ChannelMetadata `json:"metadata"`
+	// What this node joined with stays local: the verifier derived from the
+	// password of a password channel, or the invite to an invite-only one.
+	PasswordVerifier []byte  `json:"-"`
+	Invite           *Invite `json:"-"`
+	// Admitted is set once a member let this node in, or for the creator.
+	// Only admitted nodes vet joins to a channel that is not public.
+	// Waiting is set while this node is on the channel's waitlist.
+	Admitted bool `json:"-"`
+	Waiting  bool `json:"-"`
+	// Everything about who may moderate beyond the creator follows from the
+	// signed actions in ModLog.
+	ModLog []ModAction `json:"modLog,omitempty"`
+	// Derived from ModLog by Apply and Replay.
+	Roles  map[string]role.Role `json:"-"`
+	Banned map[string]bool      `json:"-"`
+	Muted  map[string]bool      `json:"-"`
+	// Waitlist holds nodes waiting for a place, in order of arrival.
+	Waitlist []string `json:"-"`
+	// LastPost is each member's latest message, for slow mode.
+	LastPost map[string]Post `json:"-"`
+	// HistoryPeers are the members this node syncs history from.
+	HistoryPeers map[string]bool `json:"-"`
 }
 
 func NewChannel(name string) Channel {
@@ -17,14 +43,52 @@ func NewChannel(name string) Channel {
 		ConnectedNodes: make(map[string]Node),
 		ChatHistory:    []Message{},
 		ChannelName:    name,
-		ConnLimit:      100,
+		Metadata:       ChannelMetadata{Channel: name},
+	}
+}
+
+// Full reports whether the channel has reached its member limit, counting
+// this node as a member.
+func (c *Channel) Full() bool {
+	return len(c.ConnectedNodes)+1 >= c.Metadata.Settings.MemberLimit()
+}
+
+// Enqueue adds hashId to the waitlist and returns its position.
+func (c *Channel) Enqueue(hashId string) int {
+	for i, waiting := range c.Waitlist {
+		if waiting == hashId {
+			return i + 1
+		}
+	}
+	c.Waitlist = append(c.Waitlist, hashId)
+	return len(c.Waitlist)
+}
+
+func (c *Channel) Dequeue(hashId string) {
+	for i, waiting := range c.Waitlist {
+		if waiting == hashId {
+			c.Waitlist = append(c.Waitlist[:i], c.Waitlist[i+1:]...)
+			return
+		}
 	}
 }
 
 func (c *Channel) ListMembers() {
-	fmt.Printf("Connected %d/100:", len(c.ConnectedNodes))
-	for _, node := range c.ConnectedNodes {
-		fmt.Println(node.Nickname)
+	fmt.Printf("Connected %d/%d:\n", len(c.ConnectedNodes)+1, c.Metadata.Settings.MemberLimit())
+
+	ids := make([]string, 0, len(c.ConnectedNodes))
+	for hashId := range c.ConnectedNodes {
+		ids = append(ids, hashId)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if c.RoleOf(ids[i]).Rank() != c.RoleOf(ids[j]).Rank() {
+			return c.RoleOf(ids[i]).Rank() > c.RoleOf(ids[j]).Rank()
+		}
+		return c.ConnectedNodes[ids[i]].Nickname < c.ConnectedNodes[ids[j]].Nickname
+	})
+
+	for _, hashId := range ids {
+		fmt.Printf("%s [%s]\n", strings.TrimSpace(c.ConnectedNodes[hashId].Nickname), c.RoleOf(hashId))
 	}
 }
 
@@ -34,8 +98,9 @@ func (c *Channel) PrintHistory() {
 	}
 }
 
+// OrderMessages sorts the history oldest first.
 func (c *Channel) OrderMessages() {
 	sort.Slice(c.ChatHistory, func(i, j int) bool {
-		return c.ChatHistory[i].Timestamp.After(c.ChatHistory[j].Timestamp)
+		return messageLess(c.ChatHistory[i], c.ChatHistory[j])
 	})
 }
@@ -5,6 +5,9 @@ import (
 )
 
 type DiscoverMessage struct {
-	NodeList  []Node    `json:"nodelist"`
-	Timestamp time.Time `json:"timestamp"`
+	NodeList []Node `json:"nodelist"`
+	Nickname string `json:"nickname"`
+	// Revocations lets a joining node learn which identities to refuse.
+	Revocations []Revocation `json:"revocations,omitempty"`
+	Timestamp   time.Time    `json:"timestamp"`
 }
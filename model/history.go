@@ -0,0 +1,122 @@
+package model
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// HistoryCursor is a position in a channel's history. Messages are ordered
+// by timestamp, then by ID.
+type HistoryCursor struct {
+	Timestamp time.Time `json:"timestamp"`
+	ID        string    `json:"id,omitempty"`
+}
+
+// Precedes reports whether msg comes after the cursor.
+func (c HistoryCursor) Precedes(msg Message) bool {
+	if !msg.Timestamp.Equal(c.Timestamp) {
+		return msg.Timestamp.After(c.Timestamp)
+	}
+	return msg.ID() > c.ID
+}
+
+// HistoryRequest asks a member for the messages of a channel after a cursor.
+// MaxBytes is the requester's message size limit, which the page must fit.
+type HistoryRequest struct {
+	Channel  string        `json:"channel"`
+	After    HistoryCursor `json:"after"`
+	MaxBytes int           `json:"maxBytes,omitempty"`
+}
+
+// HistoryPage answers a HistoryRequest with the next messages in order.
+// When More is set, the rest follows a request from Next.
+type HistoryPage struct {
+	Channel  string        `json:"channel"`
+	Messages []Message     `json:"messages"`
+	Next     HistoryCursor `json:"next"`
+	More     bool          `json:"more,omitempty"`
+}
+
+// ID identifies a message across nodes: every message carries a nonce unique
+// to its sender.
+func (message Message) ID() string {
+	return message.HashID + ":" + message.Nonce
+}
+
+func messageLess(a, b Message) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.Before(b.Timestamp)
+	}
+	return a.ID() < b.ID()
+}
+
+// Cursor returns the position of the newest message we hold.
+func (c *Channel) Cursor() HistoryCursor {
+	var cursor HistoryCursor
+	for _, msg := range c.ChatHistory {
+		if cursor.Precedes(msg) {
+			cursor = HistoryCursor{Timestamp: msg.Timestamp, ID: msg.ID()}
+		}
+	}
+	return cursor
+}
+
+// HistoryAfter returns the page of messages after a cursor, holding at most
+// maxMessages and roughly maxBytes of encoded messages. A message too large
+// for any page is left out. Messages are paged as signed ciphertext only:
+// a joiner must not learn what earlier channel keys protected.
+func (c *Channel) HistoryAfter(after HistoryCursor, maxMessages, maxBytes int) HistoryPage {
+	c.OrderMessages()
+
+	page := HistoryPage{Channel: c.ChannelName, Messages: []Message{}, Next: after}
+	size := 0
+	for _, msg := range c.ChatHistory {
+		if !after.Precedes(msg) {
+			continue
+		}
+		msg.Plaintext = ""
+		if len(page.Messages) == maxMessages {
+			page.More = true
+			break
+		}
+
+		data, _ := json.Marshal(msg)
+		if len(data) > maxBytes {
+			page.Next = HistoryCursor{Timestamp: msg.Timestamp, ID: msg.ID()}
+			continue
+		}
+		if size+len(data) > maxBytes {
+			page.More = true
+			break
+		}
+
+		size += len(data)
+		page.Messages = append(page.Messages, msg)
+		page.Next = HistoryCursor{Timestamp: msg.Timestamp, ID: msg.ID()}
+	}
+	return page
+}
+
+// MergeHistory adds the messages we do not hold yet and returns them in
+// order.
+func (c *Channel) MergeHistory(messages []Message) []Message {
+	held := make(map[string]bool, len(c.ChatHistory))
+	for _, msg := range c.ChatHistory {
+		held[msg.ID()] = true
+	}
+
+	var added []Message
+	for _, msg := range messages {
+		if msg.Channel != c.ChannelName || held[msg.ID()] {
+			continue
+		}
+		held[msg.ID()] = true
+		added = append(added, msg)
+	}
+
+	sort.Slice(added, func(i, j int) bool { return messageLess(added[i], added[j]) })
+	c.ChatHistory = append(c.ChatHistory, added...)
+	c.OrderMessages()
+	return added
+}
@@ -1,12 +1,82 @@
 package model
 
 import (
-	"crypto/rsa"
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"go-p2p/certs"
+	"sync"
 )
 
 type Identification struct {
-	PrivateKey  *rsa.PrivateKey
-	Certificate *tls.Certificate
-	Config      *tls.Config
+	PrivateKey    crypto.Signer
+	EncryptionKey *ecdh.PrivateKey
+	Config        *tls.Config
+	KnownPeers    *certs.KnownPeers
+	CAPool        *x509.CertPool
+	mu            sync.RWMutex
+	certificate   *tls.Certificate
+}
+
+// DialConfig returns the TLS config for connecting to the peer at address.
+// The peer's certificate is verified like on accepted connections: against
+// the CA in a CA network, otherwise against the certificate pinned for its
+// key. When the peer's public key is already known the certificate must also
+// carry that key.
+func (id *Identification) DialConfig(address string, publicKey []byte) *tls.Config {
+	config := id.Config.Clone()
+	if len(publicKey) == 0 {
+		return config
+	}
+
+	verify := config.VerifyPeerCertificate
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		if err := verify(rawCerts, chains); err != nil {
+			return err
+		}
+		return verifyPeerKey(rawCerts, publicKey)
+	}
+	return config
+}
+
+func verifyPeerKey(rawCerts [][]byte, publicKey []byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("peer did not present a certificate")
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("invalid peer certificate: %v", err)
+	}
+
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, publicKey) {
+		return errors.New("peer certificate does not match the node's public key")
+	}
+	return nil
+}
+
+func (id *Identification) Certificate() *tls.Certificate {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	return id.certificate
+}
+
+// SetCertificate swaps the certificate presented on new connections, so a
+// renewed certificate takes effect without restarting the listener.
+func (id *Identification) SetCertificate(cert *tls.Certificate) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	id.certificate = cert
+}
+
+func (id *Identification) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return id.Certificate(), nil
+}
+
+func (id *Identification) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return id.Certificate(), nil
 }
@@ -1,8 +1,11 @@
 package model
 
 import (
+	"crypto"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go-p2p/certs"
 	"go-p2p/enum/headerType"
 	"strings"
 	"time"
@@ -15,22 +18,86 @@ type Message struct {
 	Content   string          `json:"content"`
 	Nickname  string          `json:"nickname"`
 	HashID    string          `json:"HashId"`
-	Timestamp time.Time       `json:"timestamp"`
+	PublicKey []byte          `json:"publicKey,omitempty"`
+	// Set on NewNode so peers learn the sender's encryption key.
+	EncryptionKey    []byte `json:"encryptionKey,omitempty"`
+	EncryptionKeySig []byte `json:"encryptionKeySig,omitempty"`
+	// Recipient is the HashID a private message is encrypted to.
+	Recipient string `json:"recipient,omitempty"`
+	// Channel names the channel a chat message or channel key belongs to.
+	Channel string `json:"channel,omitempty"`
+	// Attachment marks a chat message whose content is an Attachment.
+	Attachment bool `json:"attachment,omitempty"`
+	// Plaintext is the decrypted content of a message in an encrypted
+	// channel. Content keeps the ciphertext, which is what the sender signed,
+	// so the message can still be verified when it is passed on as history.
+	Plaintext string `json:"plaintext,omitempty"`
+	// Nonce is unique per message so receivers can discard replays.
+	Nonce     string    `json:"nonce,omitempty"`
+	Signature []byte    `json:"signature,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
 }
 
 func convertTime(ts time.Time) string {
 	return ts.Format("15:04:05")
 }
 
+// Text returns the readable content of the message.
+func (message Message) Text() string {
+	if message.Plaintext != "" {
+		return message.Plaintext
+	}
+	return message.Content
+}
+
 func (message Message) PrintMessage() string {
 	cleanNN := strings.ReplaceAll(message.Nickname, "\n", "")
-	return fmt.Sprintf("%s %s: %s", convertTime(message.Timestamp), cleanNN, message.Content)
+	content := message.Text()
+	if message.Attachment {
+		if a, err := DecodeAttachment(content); err == nil {
+			content = a.String() + "\n"
+		}
+	}
+	return fmt.Sprintf("%s %s: %s", convertTime(message.Timestamp), cleanNN, content)
 }
 
 func (message Message) ConstructPacket() string {
 	return fmt.Sprintf("%s;;%s;;%s", convertTime(message.Timestamp), message.Nickname, message.Content)
 }
 
+// SigningBytes is the canonical encoding covered by the author's signature:
+// the message without its signature, with the timestamp in UTC.
+func (message Message) SigningBytes() []byte {
+	message.Signature = nil
+	message.Plaintext = ""
+	message.Timestamp = message.Timestamp.UTC()
+
+	data, _ := json.Marshal(message)
+	return data
+}
+
+func (message *Message) Sign(key crypto.Signer) error {
+	signature, err := certs.Sign(key, message.SigningBytes())
+	if err != nil {
+		return fmt.Errorf("error signing message: %v", err)
+	}
+
+	message.Signature = signature
+	return nil
+}
+
+// Verify checks the message was signed by the holder of publicKey.
+func (message Message) Verify(publicKey []byte) error {
+	if len(message.Signature) == 0 {
+		return errors.New("message is not signed")
+	}
+
+	if err := certs.VerifyPKIX(publicKey, message.SigningBytes(), message.Signature); err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	return nil
+}
+
 func (message Message) ToJson() []byte {
 	jsonData, err := json.Marshal(message)
 	if err != nil {
@@ -0,0 +1,259 @@
+package model
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/certs"
+	"go-p2p/enum/role"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrStaleMetadata  = errors.New("channel metadata is not newer than ours")
+	ErrUnknownCreator = errors.New("the creator of the channel is not known yet")
+)
+
+// ChannelMetadata describes a channel. The record is signed by its creator,
+// who alone changes the settings; the details are signed separately so any
+// operator can change the topic and description.
+type ChannelMetadata struct {
+	Channel    string          `json:"channel"`
+	Creator    string          `json:"creator,omitempty"`
+	CreatorKey []byte          `json:"creatorKey,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	Settings   ChannelSettings `json:"settings"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+	Signature  []byte          `json:"signature,omitempty"`
+	Details    ChannelDetails  `json:"details"`
+}
+
+type ChannelDetails struct {
+	Topic       string    `json:"topic,omitempty"`
+	Description string    `json:"description,omitempty"`
+	UpdatedBy   string    `json:"updatedBy,omitempty"`
+	UpdaterKey  []byte    `json:"updaterKey,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Signature   []byte    `json:"signature,omitempty"`
+}
+
+// Creation identifies one creation of the channel, so a channel created
+// again under the same name starts without the old one's history. Channels
+// nobody created, such as the lobby, have none.
+func (m ChannelMetadata) Creation() string {
+	if m.Creator == "" {
+		return ""
+	}
+	return m.Creator + "@" + strconv.FormatInt(m.CreatedAt.UnixNano(), 10)
+}
+
+func (m ChannelMetadata) Digest() []byte {
+	m.Signature = nil
+	m.Details = ChannelDetails{}
+	m.CreatedAt, m.UpdatedAt = m.CreatedAt.UTC(), m.UpdatedAt.UTC()
+
+	data, _ := json.Marshal(m)
+	hash := sha256.Sum256(append([]byte("go-p2p channel metadata:"), data...))
+	return hash[:]
+}
+
+// detailsDigest binds the details to one creation of the channel, so they
+// cannot be carried over to a channel recreated under the same name.
+func (m ChannelMetadata) detailsDigest() []byte {
+	d := m.Details
+	d.Signature = nil
+	d.UpdatedAt = d.UpdatedAt.UTC()
+
+	data, _ := json.Marshal(struct {
+		Channel   string         `json:"channel"`
+		CreatedAt time.Time      `json:"createdAt"`
+		Details   ChannelDetails `json:"details"`
+	}{m.Channel, m.CreatedAt.UTC(), d})
+	hash := sha256.Sum256(append([]byte("go-p2p channel details:"), data...))
+	return hash[:]
+}
+
+// NewChannelMetadata creates the signed record for a channel created by the
+// holder of key.
+func NewChannelMetadata(channel string, settings ChannelSettings, key crypto.Signer, publicKey []byte) (ChannelMetadata, error) {
+	now := time.Now()
+	m := ChannelMetadata{Channel: channel, CreatedAt: now, Settings: settings, UpdatedAt: now}
+	if err := m.Sign(key, publicKey); err != nil {
+		return ChannelMetadata{}, err
+	}
+	return m, nil
+}
+
+// Sign signs the record as the creator. Callers set UpdatedAt first.
+func (m *ChannelMetadata) Sign(key crypto.Signer, publicKey []byte) error {
+	m.Creator = KeyFingerprint(publicKey)
+	m.CreatorKey = publicKey
+
+	signature, err := certs.Sign(key, m.Digest())
+	if err != nil {
+		return fmt.Errorf("error signing channel metadata: %v", err)
+	}
+
+	m.Signature = signature
+	return nil
+}
+
+// SignDetails signs the details as the holder of key. Callers set the
+// details' UpdatedAt first.
+func (m *ChannelMetadata) SignDetails(key crypto.Signer, publicKey []byte) error {
+	m.Details.UpdatedBy = KeyFingerprint(publicKey)
+	m.Details.UpdaterKey = publicKey
+
+	signature, err := certs.Sign(key, m.detailsDigest())
+	if err != nil {
+		return fmt.Errorf("error signing channel details: %v", err)
+	}
+
+	m.Details.Signature = signature
+	return nil
+}
+
+func (m ChannelMetadata) Verify() error {
+	if len(m.CreatorKey) == 0 || len(m.Signature) == 0 {
+		return errors.New("channel metadata is not signed")
+	}
+
+	if KeyFingerprint(m.CreatorKey) != m.Creator {
+		return errors.New("channel metadata key does not match its creator")
+	}
+
+	if err := certs.VerifyPKIX(m.CreatorKey, m.Digest(), m.Signature); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	return nil
+}
+
+// VerifyDetails checks the signature on the details. Unsigned, empty
+// details are valid.
+func (m ChannelMetadata) VerifyDetails() error {
+	if m.Details.UpdatedBy == "" && m.Details.Topic == "" && m.Details.Description == "" {
+		return nil
+	}
+
+	if len(m.Details.UpdaterKey) == 0 || len(m.Details.Signature) == 0 {
+		return errors.New("channel details are not signed")
+	}
+
+	if KeyFingerprint(m.Details.UpdaterKey) != m.Details.UpdatedBy {
+		return errors.New("channel details key does not match its author")
+	}
+
+	if err := certs.VerifyPKIX(m.Details.UpdaterKey, m.detailsDigest(), m.Details.Signature); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	return nil
+}
+
+// Creator returns the owner of the channel, or "" for channels nobody
+// created, such as the lobby.
+func (c *Channel) Creator() string {
+	return c.Metadata.Creator
+}
+
+func (c *Channel) checkMetadata(m ChannelMetadata) error {
+	if m.Channel != c.ChannelName {
+		return fmt.Errorf("channel metadata is for channel %s", m.Channel)
+	}
+
+	if err := m.Verify(); err != nil {
+		return err
+	}
+	return m.Settings.Validate()
+}
+
+// Found takes m as the record of the channel's creation. Any key can sign a
+// record, so callers only pass one announced by its own creator; a channel
+// that already has a creator keeps it.
+func (c *Channel) Found(m ChannelMetadata) error {
+	if c.Metadata.Creator != "" {
+		return errors.New("the channel already has a creator")
+	}
+	if err := c.checkMetadata(m); err != nil {
+		return err
+	}
+
+	c.Metadata.Channel, c.Metadata.Creator, c.Metadata.CreatorKey, c.Metadata.CreatedAt = m.Channel, m.Creator, m.CreatorKey, m.CreatedAt
+	c.Metadata.Settings, c.Metadata.UpdatedAt, c.Metadata.Signature = m.Settings, m.UpdatedAt, m.Signature
+	// Roles follow from the creator, so the log is checked again.
+	c.Replay()
+
+	// The details are checked like any later update.
+	c.MergeMetadata(m)
+	return nil
+}
+
+// MergeMetadata takes the newer parts of m. The record is taken when it
+// verifies, belongs to the same creation of the channel and keeps its
+// encryption and access mode. The details are taken when they verify and
+// their author is an operator. It reports whether anything changed.
+func (c *Channel) MergeMetadata(m ChannelMetadata) (bool, error) {
+	if err := c.checkMetadata(m); err != nil {
+		return false, err
+	}
+
+	current := c.Metadata
+	if current.Creator == "" {
+		return false, ErrUnknownCreator
+	}
+	if m.Creator != current.Creator || !m.CreatedAt.Equal(current.CreatedAt) {
+		return false, errors.New("channel metadata is for another creation of the channel")
+	}
+	if m.Settings.Encrypted != current.Settings.Encrypted || m.Settings.Access != current.Settings.Access {
+		return false, errors.New("encryption and access mode cannot change")
+	}
+
+	changed := false
+	if m.UpdatedAt.After(current.UpdatedAt) {
+		c.Metadata.Settings, c.Metadata.UpdatedAt, c.Metadata.Signature = m.Settings, m.UpdatedAt, m.Signature
+		changed = true
+	}
+
+	if m.Details.UpdatedAt.After(c.Metadata.Details.UpdatedAt) {
+		if err := m.VerifyDetails(); err != nil {
+			return changed, err
+		}
+		if c.RoleOf(m.Details.UpdatedBy).Rank() < role.Operator.Rank() {
+			return changed, errors.New("channel details were not set by an operator")
+		}
+		c.Metadata.Details = m.Details
+		changed = true
+	}
+
+	if !changed {
+		return false, ErrStaleMetadata
+	}
+	return true, nil
+}
+
+// Adopt takes the metadata and moderation log of another copy of the
+// channel, checking both rather than trusting them.
+func (c *Channel) Adopt(other Channel) {
+	c.MergeMetadata(other.Metadata)
+	for _, a := range other.ModLog {
+		c.Apply(a)
+	}
+	// Details from an operator can only be checked once the roles are known.
+	c.MergeMetadata(other.Metadata)
+}
+
+// Describe prints the metadata shown when joining or listing channels.
+func (c *Channel) Describe(nickname func(string) string) {
+	m := c.Metadata
+	if m.Details.Topic != "" {
+		fmt.Println("Topic:", m.Details.Topic)
+	}
+	if m.Details.Description != "" {
+		fmt.Println(m.Details.Description)
+	}
+	if m.Creator != "" {
+		fmt.Printf("Created by %s on %s\n", nickname(m.Creator), m.CreatedAt.Local().Format("2006-01-02 15:04"))
+	}
+}
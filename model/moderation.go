@@ -0,0 +1,171 @@
+package model
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/certs"
+	"go-p2p/enum/modAction"
+	"go-p2p/enum/role"
+	"time"
+)
+
+var ErrDuplicateAction = errors.New("moderation action already applied")
+
+// ModAction is one signed moderation step in a channel: a role grant, kick,
+// ban or mute. Channels keep every action they accepted so that any member
+// can replay and recheck them.
+type ModAction struct {
+	Channel   string           `json:"channel"`
+	Action    modAction.Action `json:"action"`
+	Target    string           `json:"target,omitempty"`
+	Role      role.Role        `json:"role,omitempty"`
+	Actor     string           `json:"actor"`
+	ActorKey  []byte           `json:"actorKey"`
+	Timestamp time.Time        `json:"timestamp"`
+	Signature []byte           `json:"signature,omitempty"`
+}
+
+func (a ModAction) Digest() []byte {
+	a.Signature = nil
+	a.Timestamp = a.Timestamp.UTC()
+
+	data, _ := json.Marshal(a)
+	hash := sha256.Sum256(append([]byte("go-p2p moderation:"), data...))
+	return hash[:]
+}
+
+func (a *ModAction) Sign(key crypto.Signer, publicKey []byte) error {
+	a.Actor = KeyFingerprint(publicKey)
+	a.ActorKey = publicKey
+
+	signature, err := certs.Sign(key, a.Digest())
+	if err != nil {
+		return fmt.Errorf("error signing moderation action: %v", err)
+	}
+
+	a.Signature = signature
+	return nil
+}
+
+func (a ModAction) Verify() error {
+	if len(a.ActorKey) == 0 || len(a.Signature) == 0 {
+		return errors.New("moderation action is not signed")
+	}
+
+	if KeyFingerprint(a.ActorKey) != a.Actor {
+		return errors.New("moderation action key does not match its actor")
+	}
+
+	if err := certs.VerifyPKIX(a.ActorKey, a.Digest(), a.Signature); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	return nil
+}
+
+// RoleOf returns a node's role in the channel. The creator is always the
+// owner; everyone without a grant is a member.
+func (c *Channel) RoleOf(hashId string) role.Role {
+	if hashId != "" && hashId == c.Creator() {
+		return role.Owner
+	}
+	if r, exists := c.Roles[hashId]; exists {
+		return r
+	}
+	return role.Member
+}
+
+// Authorize checks an action against the roles currently in force. Only
+// operators and the owner moderate, and only nodes ranked below them.
+func (c *Channel) Authorize(a ModAction) error {
+	actor := c.RoleOf(a.Actor)
+	if actor.Rank() < role.Operator.Rank() {
+		return errors.New("only channel operators can moderate")
+	}
+
+	switch a.Action {
+	case modAction.SetRole:
+		if !a.Role.Valid() || a.Role == role.Owner {
+			return fmt.Errorf("cannot grant role %q", a.Role)
+		}
+		if a.Role.Rank() >= actor.Rank() {
+			return errors.New("cannot grant a role equal to or above your own")
+		}
+	case modAction.Kick, modAction.Ban, modAction.Unban, modAction.Mute, modAction.Unmute:
+	default:
+		return fmt.Errorf("unknown moderation action %q", a.Action)
+	}
+
+	if !IsFingerprint(a.Target) {
+		return errors.New("moderation action target is not a node identity")
+	}
+	if c.RoleOf(a.Target).Rank() >= actor.Rank() {
+		return errors.New("target's role is not below yours")
+	}
+	return nil
+}
+
+// Apply verifies, authorizes and records a moderation action.
+func (c *Channel) Apply(a ModAction) error {
+	if a.Channel != c.ChannelName {
+		return fmt.Errorf("moderation action is for channel %s", a.Channel)
+	}
+
+	if err := a.Verify(); err != nil {
+		return err
+	}
+
+	for _, applied := range c.ModLog {
+		if bytes.Equal(applied.Signature, a.Signature) {
+			return ErrDuplicateAction
+		}
+	}
+
+	if err := c.Authorize(a); err != nil {
+		return err
+	}
+
+	switch a.Action {
+	case modAction.SetRole:
+		if c.Roles == nil {
+			c.Roles = make(map[string]role.Role)
+		}
+		if a.Role == role.Member {
+			delete(c.Roles, a.Target)
+		} else {
+			c.Roles[a.Target] = a.Role
+		}
+	case modAction.Ban:
+		if c.Banned == nil {
+			c.Banned = make(map[string]bool)
+		}
+		c.Banned[a.Target] = true
+	case modAction.Unban:
+		delete(c.Banned, a.Target)
+	case modAction.Mute:
+		if c.Muted == nil {
+			c.Muted = make(map[string]bool)
+		}
+		c.Muted[a.Target] = true
+	case modAction.Unmute:
+		delete(c.Muted, a.Target)
+	}
+
+	c.ModLog = append(c.ModLog, a)
+	return nil
+}
+
+// Replay rebuilds roles, bans and mutes from the moderation log,
+// dropping actions that do not verify. Channel state received from another
+// node is replayed rather than trusted.
+func (c *Channel) Replay() {
+	log := c.ModLog
+	c.Roles, c.Banned, c.Muted, c.ModLog = nil, nil, nil, nil
+
+	for _, a := range log {
+		c.Apply(a)
+	}
+}
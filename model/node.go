@@ -1,23 +1,29 @@
 package model
 
 import (
-	"crypto"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go-p2p/certs"
 	"net"
+	"regexp"
 	"strings"
 )
 
+var fingerprintPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
 type Node struct {
-	Hostname   string          `json:"hostname"`
-	Port       string          `json:"port"`
-	Nickname   string          `json:"nickname"`
-	Connection net.Conn        `json:"-"`
-	Channel    Channel         `json:"channel"`
-	ID         *Identification `json:"-"`
+	Hostname  string `json:"hostname"`
+	Port      string `json:"port"`
+	Nickname  string `json:"nickname"`
+	PublicKey []byte `json:"publicKey,omitempty"`
+	// EncryptionKey is the node's X25519 key for end-to-end encryption,
+	// signed by its identity key.
+	EncryptionKey    []byte          `json:"encryptionKey,omitempty"`
+	EncryptionKeySig []byte          `json:"encryptionKeySig,omitempty"`
+	Connection       net.Conn        `json:"-"`
+	Channel          Channel         `json:"channel"`
+	ID               *Identification `json:"-"`
 }
 
 func (n Node) Address() string {
@@ -34,14 +40,38 @@ func (n Node) ToJson() []byte {
 	return jsonData
 }
 
+// KeyFingerprint is the SHA-256 of a DER encoded public key. It is also the
+// fingerprint of any certificate's SubjectPublicKeyInfo for that key.
+func KeyFingerprint(publicKey []byte) string {
+	return certs.KeyFingerprint(publicKey)
+}
+
+// IsFingerprint reports whether s has the form of a key fingerprint: 64
+// lowercase hex digits.
+func IsFingerprint(s string) bool {
+	return fingerprintPattern.MatchString(s)
+}
+
+// HashID is the node's identity: the fingerprint of its public key. Address
+// and nickname are attributes of that identity and may change.
 func (n Node) HashID() string {
-	data := fmt.Sprintf("%s:%s:%s", strings.TrimSpace(n.Hostname), strings.TrimSpace(n.Port), strings.TrimSpace(n.Nickname))
+	if len(n.PublicKey) == 0 {
+		return ""
+	}
+	return KeyFingerprint(n.PublicKey)
+}
 
-	hash := sha256.New()
-	hash.Write([]byte(data))
-	hashBytes := hash.Sum(nil)
+func EncryptionKeyDigest(key []byte) []byte {
+	return append([]byte("go-p2p encryption key:"), key...)
+}
 
-	return fmt.Sprintf("%x", hashBytes)
+// VerifyEncryptionKey checks the node's encryption key was signed by its
+// identity key.
+func (n Node) VerifyEncryptionKey() error {
+	if len(n.EncryptionKey) == 0 {
+		return errors.New("node has no encryption key")
+	}
+	return certs.VerifyPKIX(n.PublicKey, EncryptionKeyDigest(n.EncryptionKey), n.EncryptionKeySig)
 }
 
 func (n Node) SignHash() ([]byte, error) {
@@ -49,7 +79,7 @@ func (n Node) SignHash() ([]byte, error) {
 
 	hashBytes := []byte(hashID)
 
-	signature, err := rsa.SignPKCS1v15(rand.Reader, n.ID.PrivateKey, crypto.SHA256, hashBytes)
+	signature, err := certs.Sign(n.ID.PrivateKey, hashBytes)
 	if err != nil {
 		return nil, fmt.Errorf("error signing hash: %v", err)
 	}
@@ -60,7 +90,7 @@ func (n Node) SignHash() ([]byte, error) {
 func (n Node) VerifySignature(hashID string, signature []byte) (bool, error) {
 	hashBytes := []byte(hashID)
 
-	err := rsa.VerifyPKCS1v15(&n.ID.PrivateKey.PublicKey, crypto.SHA256, hashBytes, signature)
+	err := certs.VerifyPKIX(n.PublicKey, hashBytes, signature)
 	if err != nil {
 		return false, fmt.Errorf("verification failed: %v", err)
 	}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/certs"
+	"strings"
+	"time"
+)
+
+// Registration is what a node posts to a mirror to claim its nickname. The
+// signature proves the node holds the private half of Node.PublicKey.
+type Registration struct {
+	Node      Node      `json:"node"`
+	Timestamp time.Time `json:"timestamp"`
+	Signature []byte    `json:"signature"`
+}
+
+func (r Registration) Digest() []byte {
+	data, _ := json.Marshal(struct {
+		Hostname  string    `json:"hostname"`
+		Port      string    `json:"port"`
+		Nickname  string    `json:"nickname"`
+		PublicKey []byte    `json:"publicKey"`
+		Timestamp time.Time `json:"timestamp"`
+	}{strings.TrimSpace(r.Node.Hostname), strings.TrimSpace(r.Node.Port), strings.TrimSpace(r.Node.Nickname), r.Node.PublicKey, r.Timestamp.UTC()})
+	hash := sha256.Sum256(append([]byte("go-p2p registration:"), data...))
+	return hash[:]
+}
+
+func (r *Registration) Sign(key crypto.Signer) error {
+	signature, err := certs.Sign(key, r.Digest())
+	if err != nil {
+		return fmt.Errorf("error signing registration: %v", err)
+	}
+
+	r.Signature = signature
+	return nil
+}
+
+func (r Registration) Verify() error {
+	if len(r.Node.PublicKey) == 0 || len(r.Signature) == 0 {
+		return errors.New("registration is not signed")
+	}
+
+	if err := certs.VerifyPKIX(r.Node.PublicKey, r.Digest(), r.Signature); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+
+	return nil
+}
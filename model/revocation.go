@@ -0,0 +1,141 @@
+package model
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/certs"
+	"os"
+	"sync"
+	"time"
+)
+
+// Revocation withdraws trust in an identity key. It is signed by the key it
+// revokes, so anyone can check and pass it on, and it can be created ahead of
+// time and kept offline in case the key is lost.
+type Revocation struct {
+	PublicKey []byte    `json:"publicKey"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+	Signature []byte    `json:"signature"`
+}
+
+func (r Revocation) Digest() []byte {
+	data := fmt.Sprintf("go-p2p revocation:%x:%s:%d", r.PublicKey, r.Reason, r.Timestamp.UnixNano())
+
+	hash := sha256.Sum256([]byte(data))
+	return hash[:]
+}
+
+func (r Revocation) Identity() string {
+	return KeyFingerprint(r.PublicKey)
+}
+
+func NewRevocation(key crypto.Signer, publicKey []byte, reason string) (Revocation, error) {
+	r := Revocation{PublicKey: publicKey, Reason: reason, Timestamp: time.Now()}
+
+	signature, err := certs.Sign(key, r.Digest())
+	if err != nil {
+		return Revocation{}, fmt.Errorf("error signing revocation: %v", err)
+	}
+
+	r.Signature = signature
+	return r, nil
+}
+
+func (r Revocation) Verify() error {
+	if len(r.PublicKey) == 0 || len(r.Signature) == 0 {
+		return errors.New("revocation is not signed")
+	}
+
+	if err := certs.VerifyPKIX(r.PublicKey, r.Digest(), r.Signature); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+
+	return nil
+}
+
+// RevocationList is a persistent set of verified revocations keyed by the
+// identity they revoke. Nodes and mirrors both keep one.
+type RevocationList struct {
+	mu      sync.Mutex
+	path    string
+	revoked map[string]Revocation
+}
+
+func LoadRevocationList(path string) (*RevocationList, error) {
+	l := &RevocationList{path: path, revoked: make(map[string]Revocation)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	var revocations []Revocation
+	if err := json.Unmarshal(data, &revocations); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %v", path, err)
+	}
+
+	for _, r := range revocations {
+		if err := r.Verify(); err != nil {
+			fmt.Println("Skipping invalid revocation in", path+":", err)
+			continue
+		}
+		l.revoked[r.Identity()] = r
+	}
+	return l, nil
+}
+
+// Add verifies and stores r. It reports false if the identity was already
+// revoked, so callers only propagate a revocation the first time they see it.
+func (l *RevocationList) Add(r Revocation) (bool, error) {
+	if err := r.Verify(); err != nil {
+		return false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, exists := l.revoked[r.Identity()]; exists {
+		return false, nil
+	}
+	l.revoked[r.Identity()] = r
+
+	revocations := make([]Revocation, 0, len(l.revoked))
+	for _, revocation := range l.revoked {
+		revocations = append(revocations, revocation)
+	}
+
+	data, err := json.MarshalIndent(revocations, "", "  ")
+	if err != nil {
+		return true, err
+	}
+	if err := os.WriteFile(l.path, data, 0600); err != nil {
+		return true, fmt.Errorf("error writing %s: %v", l.path, err)
+	}
+	return true, nil
+}
+
+func (l *RevocationList) Revoked(identity string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, exists := l.revoked[identity]
+	return exists
+}
+
+func (l *RevocationList) All() []Revocation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	revocations := make([]Revocation, 0, len(l.revoked))
+	for _, r := range l.revoked {
+		revocations = append(revocations, r)
+	}
+	return revocations
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Iterating the hash makes it expensive to search for a key whose safety
+// number collides with someone else's.
+const safetyIterations = 5200
+
+// safetyDigits turns one identity key into 30 decimal digits.
+func safetyDigits(publicKey []byte) string {
+	digest := sha512.Sum512(append([]byte("go-p2p safety number"), publicKey...))
+	for i := 1; i < safetyIterations; i++ {
+		digest = sha512.Sum512(append(digest[:], publicKey...))
+	}
+
+	var digits strings.Builder
+	for i := 0; i < 6; i++ {
+		chunk := binary.BigEndian.Uint64(append([]byte{0, 0, 0}, digest[i*5:i*5+5]...))
+		fmt.Fprintf(&digits, "%05d", chunk%100000)
+	}
+	return digits.String()
+}
+
+// SafetyNumber is a 60 digit number both parties of a conversation compute
+// from their two identity keys. It is the same on both sides, so reading it
+// to each other over a trusted channel proves neither key was substituted.
+func SafetyNumber(ours, theirs []byte) string {
+	first, second := ours, theirs
+	if bytes.Compare(first, second) > 0 {
+		first, second = second, first
+	}
+
+	digits := safetyDigits(first) + safetyDigits(second)
+
+	groups := make([]string, 0, len(digits)/5)
+	for i := 0; i < len(digits); i += 5 {
+		groups = append(groups, digits[i:i+5])
+	}
+	return strings.Join(groups, " ")
+}
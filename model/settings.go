@@ -0,0 +1,225 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"go-p2p/enum/accessMode"
+	"go-p2p/enum/role"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const DefaultConnLimit = 100
+
+// ChannelSettings are the parts of a channel only its owner may change.
+// Encryption and access mode are fixed when the channel is created. Zero
+// values mean no restriction, except that attachments are off by default.
+type ChannelSettings struct {
+	Encrypted bool            `json:"encrypted,omitempty"`
+	Access    accessMode.Mode `json:"access,omitempty"`
+	ConnLimit int             `json:"connLimit,omitempty"`
+	Waitlist  bool            `json:"waitlist,omitempty"`
+	// MaxLength limits the characters in a text message.
+	MaxLength int `json:"maxLength,omitempty"`
+	// SlowMode is the number of seconds members wait between messages.
+	// Operators are exempt.
+	SlowMode    int  `json:"slowMode,omitempty"`
+	Attachments bool `json:"attachments,omitempty"`
+	// Retention is the number of hours history is kept for.
+	Retention int `json:"retention,omitempty"`
+	// Posting is the lowest role allowed to post.
+	Posting role.Role `json:"posting,omitempty"`
+}
+
+// MemberLimit returns the member limit, defaulting for channels that never
+// set one, such as the lobby.
+func (s ChannelSettings) MemberLimit() int {
+	if s.ConnLimit < 1 {
+		return DefaultConnLimit
+	}
+	return s.ConnLimit
+}
+
+func (s ChannelSettings) Validate() error {
+	if s.ConnLimit < 0 || s.MaxLength < 0 || s.SlowMode < 0 || s.Retention < 0 {
+		return errors.New("channel settings cannot be negative")
+	}
+	if s.Posting != "" && (!s.Posting.Valid() || s.Posting == role.Owner) {
+		return fmt.Errorf("invalid posting role %q", s.Posting)
+	}
+	return nil
+}
+
+// Set changes one setting by the name used in the /set command.
+func (s *ChannelSettings) Set(name, value string) error {
+	number := func() (int, error) {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%s needs a number, got %q", name, value)
+		}
+		return n, nil
+	}
+	toggle := func() (bool, error) {
+		if value != "on" && value != "off" {
+			return false, fmt.Errorf("%s is on or off, got %q", name, value)
+		}
+		return value == "on", nil
+	}
+
+	var err error
+	switch name {
+	case "limit":
+		s.ConnLimit, err = number()
+	case "waitlist":
+		s.Waitlist, err = toggle()
+	case "maxlength":
+		s.MaxLength, err = number()
+	case "slowmode":
+		s.SlowMode, err = number()
+	case "attachments":
+		s.Attachments, err = toggle()
+	case "retention":
+		s.Retention, err = number()
+	case "posting":
+		s.Posting = role.Role(value)
+	default:
+		return fmt.Errorf("unknown setting %s", name)
+	}
+	if err != nil {
+		return err
+	}
+	return s.Validate()
+}
+
+func (s ChannelSettings) String() string {
+	onOff := func(b bool) string {
+		if b {
+			return "on"
+		}
+		return "off"
+	}
+	orNone := func(n int, unit string) string {
+		if n == 0 {
+			return "none"
+		}
+		return strconv.Itoa(n) + unit
+	}
+	posting := s.Posting
+	if posting == "" {
+		posting = role.Member
+	}
+
+	return strings.Join([]string{
+		fmt.Sprintf("limit %d", s.MemberLimit()),
+		"waitlist " + onOff(s.Waitlist),
+		"maxlength " + orNone(s.MaxLength, ""),
+		"slowmode " + orNone(s.SlowMode, "s"),
+		"attachments " + onOff(s.Attachments),
+		"retention " + orNone(s.Retention, "h"),
+		"posting " + string(posting),
+	}, ", ")
+}
+
+// MayPost reports why hashId may not post in the channel, if it may not.
+func (c *Channel) MayPost(hashId string) error {
+	if c.Banned[hashId] {
+		return errors.New("banned from the channel")
+	}
+	if c.Muted[hashId] {
+		return errors.New("muted in the channel")
+	}
+	if minimum := c.Metadata.Settings.Posting; minimum != "" && c.RoleOf(hashId).Rank() < minimum.Rank() {
+		return fmt.Errorf("only %s and above may post", minimum)
+	}
+	return nil
+}
+
+// CheckMessage applies the channel settings to a readable chat message. Every
+// node runs it on send and on receive.
+func (c *Channel) CheckMessage(msg Message) error {
+	if err := c.CheckHistory(msg); err != nil {
+		return err
+	}
+
+	settings := c.Metadata.Settings
+	if settings.SlowMode > 0 && c.RoleOf(msg.HashID).Rank() < role.Operator.Rank() {
+		wait := time.Duration(settings.SlowMode) * time.Second
+		// The last post itself passes, as it does when our own message
+		// comes back to us.
+		if last, posted := c.LastPost[msg.HashID]; posted && last.ID != msg.ID() && time.Since(last.At) < wait {
+			return fmt.Errorf("slow mode: one message every %d seconds", settings.SlowMode)
+		}
+	}
+	return nil
+}
+
+// CheckHistory applies the settings that hold for a message whenever it was
+// sent, which is all of them but slow mode.
+func (c *Channel) CheckHistory(msg Message) error {
+	if msg.Channel != c.ChannelName {
+		return fmt.Errorf("message is for channel %s", msg.Channel)
+	}
+	if err := c.MayPost(msg.HashID); err != nil {
+		return err
+	}
+
+	settings := c.Metadata.Settings
+	if msg.Attachment {
+		if !settings.Attachments {
+			return errors.New("attachments are not allowed")
+		}
+	} else if settings.MaxLength > 0 && utf8.RuneCountInString(strings.TrimRight(msg.Text(), "\n")) > settings.MaxLength {
+		return fmt.Errorf("messages are limited to %d characters", settings.MaxLength)
+	}
+	return nil
+}
+
+// Post is a member's latest message and when this node sent or received
+// it. Slow mode measures from that moment rather than from the timestamp the
+// sender put on the message.
+type Post struct {
+	ID string
+	At time.Time
+}
+
+// MarkPosted records msg as its sender's latest message, seen at at.
+func (c *Channel) MarkPosted(msg Message, at time.Time) {
+	if c.LastPost == nil {
+		c.LastPost = make(map[string]Post)
+	}
+	c.LastPost[msg.HashID] = Post{ID: msg.ID(), At: at}
+}
+
+// Record adds an accepted message to the history and drops what the
+// retention setting no longer keeps. It reports false for a message already
+// held, which can arrive both live and in a history page.
+func (c *Channel) Record(msg Message) bool {
+	for i := len(c.ChatHistory) - 1; i >= 0; i-- {
+		if c.ChatHistory[i].ID() == msg.ID() {
+			return false
+		}
+	}
+	c.MarkPosted(msg, time.Now())
+
+	c.ChatHistory = append(c.ChatHistory, msg)
+	c.Prune(time.Now())
+	return true
+}
+
+// Prune drops messages older than the retention setting.
+func (c *Channel) Prune(now time.Time) {
+	if c.Metadata.Settings.Retention <= 0 {
+		return
+	}
+
+	cutoff := now.Add(-time.Duration(c.Metadata.Settings.Retention) * time.Hour)
+	kept := c.ChatHistory[:0]
+	for _, msg := range c.ChatHistory {
+		if msg.Timestamp.After(cutoff) {
+			kept = append(kept, msg)
+		}
+	}
+	c.ChatHistory = kept
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go-p2p/certs"
+	"go-p2p/enum/keyAlgorithm"
+	"go-p2p/utils"
+	"os"
+	"time"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  go run netca.go init [-dir dir] [-name name] [-keyalg ed25519|ecdsa-p256|rsa] [-encrypt]")
+	fmt.Println("  go run netca.go csr [-key key.pem] [-out node.csr] <hostname> <port>")
+	fmt.Println("  go run netca.go sign [-dir dir] [-days n] [-out cert.pem] <node.csr>")
+}
+
+func promptPassphrase(prompt string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return utils.ReadPassphrase(prompt)
+	}
+}
+
+func newPassphrase() ([]byte, error) {
+	passphrase, err := utils.ReadPassphrase("CA key passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+
+	confirm, err := utils.ReadPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(passphrase, confirm) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+func initCA(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory to write ca.pem and ca-key.pem to")
+	name := fs.String("name", "go-p2p network CA", "CA common name")
+	alg := fs.String("keyalg", string(keyAlgorithm.Default), "CA key algorithm: ed25519, ecdsa-p256 or rsa")
+	encrypt := fs.Bool("encrypt", true, "encrypt the CA key with a passphrase")
+	fs.Parse(args)
+
+	var passphrase []byte
+	if *encrypt {
+		var err error
+		passphrase, err = newPassphrase()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := certs.GenerateCA(*dir, *name, keyAlgorithm.Algorithm(*alg), passphrase); err != nil {
+		return err
+	}
+
+	fmt.Printf("Generated %s and %s. Distribute %s to every node's certs directory and keep %s private.\n", certs.CAFile, certs.CAKeyFile, certs.CAFile, certs.CAKeyFile)
+	return nil
+}
+
+func createCSR(args []string) error {
+	fs := flag.NewFlagSet("csr", flag.ExitOnError)
+	keyPath := fs.String("key", "../profiles/default/key.pem", "node private key")
+	out := fs.String("out", "node.csr", "file to write the request to")
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	pk, err := certs.LoadPrivateKey(*keyPath, promptPassphrase("Node key passphrase: "))
+	if err != nil {
+		return err
+	}
+
+	csr, err := certs.CreateCSR(pk, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(*out, csr, 0644); err != nil {
+		return fmt.Errorf("error writing CSR: %v", err)
+	}
+
+	fmt.Println("Wrote", *out, "- send it to the network CA to be signed")
+	return nil
+}
+
+func signCSR(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory containing ca.pem and ca-key.pem")
+	days := fs.Int("days", 365, "validity of the issued certificate in days")
+	out := fs.String("out", "cert.pem", "file to write the node certificate to")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	caCert, caKey, err := certs.LoadCA(*dir, promptPassphrase("CA key passphrase: "))
+	if err != nil {
+		return err
+	}
+
+	csr, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("error reading CSR: %v", err)
+	}
+
+	cert, err := certs.SignCSR(caCert, caKey, csr, time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(*out, cert, 0644); err != nil {
+		return fmt.Errorf("error writing node cert: %v", err)
+	}
+
+	fmt.Println("Wrote", *out, "- install it as the node's cert.pem alongside ca.pem")
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		return
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "init":
+		err = initCA(os.Args[2:])
+	case "csr":
+		err = createCSR(os.Args[2:])
+	case "sign":
+		err = signCSR(os.Args[2:])
+	default:
+		usage()
+		return
+	}
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,69 @@
+package profile
+
+import (
+	"go-p2p/enum/headerType"
+	"time"
+)
+
+// Rate is a token bucket: a peer may send Burst messages at once and
+// PerSecond messages per second after that.
+type Rate struct {
+	PerSecond float64 `json:"perSecond"`
+	Burst     int     `json:"burst"`
+}
+
+// Limits bound what a single peer may send before it is disconnected and
+// banned for BanSeconds.
+type Limits struct {
+	MaxMessageBytes int `json:"maxMessageBytes,omitempty"`
+	// Rates are keyed by message type; types not listed use "*".
+	Rates      map[string]Rate `json:"rates,omitempty"`
+	BanSeconds int             `json:"banSeconds,omitempty"`
+}
+
+const anyType = "*"
+
+func DefaultLimits() Limits {
+	return Limits{
+		MaxMessageBytes: 64 * 1024,
+		Rates: map[string]Rate{
+			anyType:                           {PerSecond: 10, Burst: 50},
+			string(headerType.ChatMessage):    {PerSecond: 5, Burst: 20},
+			string(headerType.PrivateMessage): {PerSecond: 5, Burst: 20},
+			string(headerType.NewNode):        {PerSecond: 1, Burst: 5},
+		},
+		BanSeconds: 600,
+	}
+}
+
+// WithDefaults fills in every limit left unset.
+func (l Limits) WithDefaults() Limits {
+	defaults := DefaultLimits()
+
+	if l.MaxMessageBytes <= 0 {
+		l.MaxMessageBytes = defaults.MaxMessageBytes
+	}
+	if l.BanSeconds <= 0 {
+		l.BanSeconds = defaults.BanSeconds
+	}
+	if l.Rates == nil {
+		l.Rates = make(map[string]Rate)
+	}
+	for t, rate := range defaults.Rates {
+		if _, exists := l.Rates[t]; !exists {
+			l.Rates[t] = rate
+		}
+	}
+	return l
+}
+
+func (l Limits) RateFor(t headerType.Type) Rate {
+	if rate, exists := l.Rates[string(t)]; exists {
+		return rate
+	}
+	return l.Rates[anyType]
+}
+
+func (l Limits) BanDuration() time.Duration {
+	return time.Duration(l.BanSeconds) * time.Second
+}
@@ -0,0 +1,111 @@
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/enum/keyAlgorithm"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+const (
+	DefaultName = "default"
+
+	CertFile          = "cert.pem"
+	KeyFile           = "key.pem"
+	EncryptionKeyFile = "encryption-key.pem"
+	KnownPeersFile    = "known_peers"
+	SettingsFile      = "settings.json"
+	HistoryDir        = "history"
+	SessionsDir       = "sessions"
+	VerifiedFile      = "verified.json"
+	BlockListFile     = "blocklist.json"
+	RevocationsFile   = "revocations.json"
+	AttachmentsDir    = "attachments"
+)
+
+var DefaultBaseDir = filepath.Join("..", "profiles")
+
+// validName keeps profile names to a single path element; the leading
+// alphanumeric rules out "." and "..".
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Settings are the per-profile defaults used when starting a node, so a
+// profile can be started again without repeating its address and nickname.
+type Settings struct {
+	Hostname     string                 `json:"hostname,omitempty"`
+	Port         string                 `json:"port,omitempty"`
+	Nickname     string                 `json:"nickname,omitempty"`
+	KeyAlgorithm keyAlgorithm.Algorithm `json:"keyAlgorithm,omitempty"`
+	Limits       Limits                 `json:"limits"`
+}
+
+// Profile is a named identity directory holding a node's key, certificate,
+// known peers, history and settings. Several nodes can run on one machine by
+// using different profiles.
+type Profile struct {
+	Name     string
+	Dir      string
+	Settings Settings
+}
+
+func Load(baseDir, name string) (*Profile, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid profile name %q", name)
+	}
+
+	p := &Profile{Name: name, Dir: filepath.Join(baseDir, name)}
+	if err := os.MkdirAll(p.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating profile %s: %v", name, err)
+	}
+
+	data, err := os.ReadFile(p.Path(SettingsFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading profile settings: %v", err)
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(data, &p.Settings); err != nil {
+			return nil, fmt.Errorf("error decoding profile settings: %v", err)
+		}
+	}
+
+	p.Settings.Limits = p.Settings.Limits.WithDefaults()
+	return p, nil
+}
+
+func (p *Profile) Path(file string) string {
+	return filepath.Join(p.Dir, file)
+}
+
+func (p *Profile) Save() error {
+	data, err := json.MarshalIndent(p.Settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding profile settings: %v", err)
+	}
+
+	if err := os.WriteFile(p.Path(SettingsFile), data, 0600); err != nil {
+		return fmt.Errorf("error writing profile settings: %v", err)
+	}
+	return nil
+}
+
+func List(baseDir string) ([]string, error) {
+	entries, err := os.ReadDir(baseDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && validName.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
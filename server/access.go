@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/enum/accessMode"
+	"go-p2p/enum/headerType"
+	"go-p2p/enum/role"
+	"go-p2p/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// joinRequest is what this node announces for its current channel, with the
+// proof or invite the channel's members need to let us in.
+func (s *Server) joinRequest() model.JoinRequest {
+	channel := &s.thisServer.Channel
+	req := model.JoinRequest{Channel: channel.ChannelName, Invite: channel.Invite}
+	if channel.PasswordVerifier != nil {
+		req.Proof = model.JoinProof(channel.PasswordVerifier, channel.ChannelName, s.thisServer.HashID())
+	}
+	return req
+}
+
+var (
+	errNotAdmitted = errors.New("not admitted to the channel yet")
+	// errDeferred marks a join left to the channel coordinator.
+	errDeferred    = errors.New("left to the channel coordinator")
+	errChannelFull = errors.New("the channel is full")
+)
+
+// admitsJoins reports whether this node vets joins to its current channel.
+// A node whose own join to a restricted channel was not accepted yet leaves
+// that to the members.
+func (s *Server) admitsJoins() bool {
+	channel := &s.thisServer.Channel
+	return channel.Admitted || channel.Metadata.Settings.Access == "" || channel.Metadata.Settings.Access == accessMode.Public
+}
+
+// parseJoinRequest decodes the content of an UpdateChannel message.
+func parseJoinRequest(content string) (model.JoinRequest, error) {
+	var req model.JoinRequest
+	if err := json.Unmarshal([]byte(content), &req); err != nil {
+		return req, fmt.Errorf("error decoding join request: %v", err)
+	}
+	if req.Channel == "" {
+		return req, errors.New("join request names no channel")
+	}
+	return req, nil
+}
+
+// prepareJoin checks the credential given for a channel against its access
+// mode and stores what our join request will carry.
+func (s *Server) prepareJoin(channel *model.Channel, credential string) error {
+	switch channel.Metadata.Settings.Access {
+	case accessMode.Password:
+		if credential == "" {
+			return fmt.Errorf("%s is password-protected, use /join %s <password>", channel.ChannelName, channel.ChannelName)
+		}
+
+		verifier, err := model.PasswordVerifier(channel.ChannelName, channel.Creator(), credential)
+		if err != nil {
+			return fmt.Errorf("error deriving password verifier: %v", err)
+		}
+		channel.PasswordVerifier = verifier
+	case accessMode.Invite:
+		if channel.RoleOf(s.thisServer.HashID()).Rank() >= role.Operator.Rank() {
+			return nil
+		}
+		if credential == "" {
+			return fmt.Errorf("%s is invite-only, use /join %s <invite>", channel.ChannelName, channel.ChannelName)
+		}
+
+		inv, err := model.ParseInvite(credential)
+		if err != nil {
+			return err
+		}
+		if err := channel.CheckInvite(inv, s.thisServer.HashID()); err != nil {
+			return err
+		}
+		channel.Invite = &inv
+	}
+	return nil
+}
+
+// invite issues an invite to the current channel, bound to target unless it
+// is empty, and sends it to the invitee when they are online.
+func (s *Server) invite(target string, valid time.Duration) {
+	channel := &s.thisServer.Channel
+	if channel.Metadata.Settings.Access != accessMode.Invite {
+		fmt.Println("This channel is not invite-only")
+		return
+	}
+
+	if channel.RoleOf(s.thisServer.HashID()).Rank() < role.Operator.Rank() {
+		fmt.Println("Only channel operators can invite")
+		return
+	}
+
+	inv := model.Invite{Channel: channel.ChannelName, Invitee: target}
+	if valid > 0 {
+		inv.Expires = time.Now().Add(valid)
+	}
+	if err := inv.Sign(s.thisServer.ID.PrivateKey, s.thisServer.PublicKey); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	token := inv.Token()
+	fmt.Println("Invite token:", token)
+
+	if _, online := s.knownNodes[target]; online {
+		text := fmt.Sprintf("You are invited to %s: /join %s %s", channel.ChannelName, channel.ChannelName, token)
+		if err := s.sendPrivateMessage(text, target); err != nil {
+			fmt.Println("Error sending invite:", err)
+		}
+	}
+}
+
+func (s *Server) inviteCommand(fields []string) {
+	if len(fields) < 2 || len(fields) > 3 {
+		fmt.Println("Usage: /invite <nickname|id|fingerprint|anyone> [hours]")
+		return
+	}
+
+	var valid time.Duration
+	if len(fields) == 3 {
+		hours, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || hours <= 0 {
+			fmt.Println("Invalid number of hours:", fields[2])
+			return
+		}
+		valid = time.Duration(hours * float64(time.Hour))
+	}
+
+	if strings.EqualFold(fields[1], "anyone") {
+		s.invite("", valid)
+		return
+	}
+
+	target, _, err := s.resolvePeer(fields[1])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	s.invite(target, valid)
+}
+
+// createCommand parses /create <channel> [encrypted] [invite | password <password>].
+func (s *Server) createCommand(fields []string, line string) {
+	usage := "Usage: /create <channel> [encrypted] [invite | password <password>]"
+	if len(fields) < 2 {
+		fmt.Println(usage)
+		return
+	}
+
+	encrypted, access, password := false, accessMode.Public, ""
+options:
+	for i := 2; i < len(fields); i++ {
+		switch fields[i] {
+		case "encrypted":
+			encrypted = true
+		case "invite":
+			access = accessMode.Invite
+		case "password":
+			access, password = accessMode.Password, argsAfter(line, i+1)
+			if password == "" {
+				fmt.Println(usage)
+				return
+			}
+			break options
+		default:
+			fmt.Println(usage)
+			return
+		}
+	}
+
+	if _, exists := s.channels[fields[1]]; exists {
+		fmt.Println("Channel already exists:", fields[1])
+		return
+	}
+	s.CreateChannel(fields[1], encrypted, access, password)
+}
+
+// admitMember lets node into our channel and sends it the channel info with
+// the history.
+func (s *Server) admitMember(node *model.Node) {
+	hashId := node.HashID()
+	s.thisServer.Channel.Dequeue(hashId)
+	s.thisServer.Channel.ConnectedNodes[hashId] = *node
+	if channel, exists := s.channels[s.thisServer.Channel.ChannelName]; exists {
+		channel.ConnectedNodes[hashId] = *node
+	}
+	fmt.Printf("%s has joined the channel.\n", node.Nickname)
+
+	// History follows in pages the new member asks for.
+	info := s.thisServer.Channel
+	info.ChatHistory = nil
+	channelInfo, _ := json.Marshal(info)
+	msg := model.Message{Type: headerType.ChannelInfo, Hostname: s.thisServer.Hostname, Port: s.thisServer.Port, Content: string(channelInfo), Nickname: s.thisServer.Nickname, Timestamp: time.Now(), HashID: s.thisServer.HashID()}
+	jsonData, _ := s.encodeMessage(&msg)
+	// Peers ban whoever sends more than the size limit, so an oversized
+	// frame is never sent; the new member hears from other members.
+	if len(jsonData) > s.frameLimit(0) {
+		fmt.Printf("Channel info for %s is larger than the %d byte limit, not sent\n", strings.TrimSpace(node.Nickname), s.frameLimit(0))
+	} else {
+		node.Connection.Write(jsonData)
+	}
+	s.channelMemberJoined(hashId)
+}
+
+// decidesJoins reports whether this node has the final word on joins to its
+// current channel. Outside the lobby only the coordinator admits, rejects
+// and keeps the waitlist, so members never disagree on who is in.
+func (s *Server) decidesJoins() bool {
+	return s.thisServer.Channel.ChannelName == defaultChannelName || s.isChannelCoordinator("")
+}
+
+// announceAdmission tells the other members that we let node in.
+func (s *Server) announceAdmission(node *model.Node) {
+	channel := &s.thisServer.Channel
+	if channel.ChannelName == defaultChannelName {
+		return
+	}
+
+	content, err := json.Marshal(model.JoinAdmission{Channel: channel.ChannelName, Member: node.HashID()})
+	if err != nil {
+		fmt.Println("Error encoding join admission:", err)
+		return
+	}
+
+	msg := model.Message{Type: headerType.JoinAdmitted, Content: string(content), Nickname: s.thisServer.Nickname, Timestamp: time.Now(), HashID: s.thisServer.HashID()}
+	jsonData, err := s.encodeMessage(&msg)
+	if err != nil {
+		fmt.Println("Error encoding JSON:", err)
+		return
+	}
+	for hashId, member := range channel.ConnectedNodes {
+		if hashId != node.HashID() {
+			member.Connection.Write(jsonData)
+		}
+	}
+}
+
+// receiveJoinAdmission admits a node the coordinator of our channel let in.
+func (s *Server) receiveJoinAdmission(incomingMsg model.Message) {
+	var admission model.JoinAdmission
+	if err := json.Unmarshal([]byte(incomingMsg.Content), &admission); err != nil {
+		fmt.Println("Error decoding join admission:", err)
+		return
+	}
+
+	channel := &s.thisServer.Channel
+	if admission.Channel != channel.ChannelName || !isMember(channel, incomingMsg.HashID) || isMember(channel, admission.Member) {
+		return
+	}
+	node, exists := s.knownNodes[admission.Member]
+	if !exists || node.Channel.ChannelName != channel.ChannelName {
+		return
+	}
+	s.admitMember(node)
+}
+
+// rejectJoin tells node why it was not let in. When the channel is only
+// full and keeps a waitlist, the node is queued instead.
+func (s *Server) rejectJoin(node *model.Node, reason error) {
+	channel := &s.thisServer.Channel
+	nickname := strings.TrimSpace(node.Nickname)
+	rejection := model.JoinRejection{Channel: channel.ChannelName, Reason: reason.Error()}
+
+	if reason == errChannelFull && channel.Metadata.Settings.Waitlist {
+		rejection.Position = channel.Enqueue(node.HashID())
+		fmt.Printf("%s is waiting for a place (position %d).\n", nickname, rejection.Position)
+	} else {
+		fmt.Printf("Refused %s: %v\n", nickname, reason)
+	}
+
+	content, err := json.Marshal(rejection)
+	if err != nil {
+		fmt.Println("Error encoding join rejection:", err)
+		return
+	}
+
+	msg := model.Message{Type: headerType.JoinRejected, Content: string(content), Nickname: s.thisServer.Nickname, Timestamp: time.Now(), HashID: s.thisServer.HashID()}
+	jsonData, err := s.encodeMessage(&msg)
+	if err != nil {
+		fmt.Println("Error encoding JSON:", err)
+		return
+	}
+	node.Connection.Write(jsonData)
+}
+
+// receiveJoinRejection handles a member turning down our join. Only the
+// first answer counts, and only while we have not been let in.
+func (s *Server) receiveJoinRejection(incomingMsg model.Message) {
+	var rejection model.JoinRejection
+	if err := json.Unmarshal([]byte(incomingMsg.Content), &rejection); err != nil {
+		fmt.Println("Error decoding join rejection:", err)
+		return
+	}
+
+	channel := &s.thisServer.Channel
+	if rejection.Channel != channel.ChannelName || channel.Admitted {
+		return
+	}
+	if known, exists := s.channels[rejection.Channel]; !exists || (known.Creator() != incomingMsg.HashID && !isMember(known, incomingMsg.HashID)) {
+		return
+	}
+
+	if rejection.Position > 0 {
+		if !channel.Waiting {
+			channel.Waiting = true
+			fmt.Printf("%s is full; you are number %d on its waitlist.\n", rejection.Channel, rejection.Position)
+		}
+		return
+	}
+
+	fmt.Printf("Could not join %s: %s\n", rejection.Channel, rejection.Reason)
+	s.ChangeChannel(defaultChannelName, "")
+}
+
+func isMember(channel *model.Channel, hashId string) bool {
+	_, member := channel.ConnectedNodes[hashId]
+	return member
+}
+
+// memberLeft runs once a member is gone from our channel: the key is
+// rotated and the next waiting nodes are let in.
+func (s *Server) memberLeft() {
+	s.channelMemberLeft()
+	s.admitFromWaitlist()
+}
+
+func (s *Server) admitFromWaitlist() {
+	channel := &s.thisServer.Channel
+	if !s.admitsJoins() || !s.decidesJoins() {
+		return
+	}
+
+	for len(channel.Waitlist) > 0 && !channel.Full() {
+		hashId := channel.Waitlist[0]
+		channel.Dequeue(hashId)
+
+		if node, exists := s.knownNodes[hashId]; exists && node.Channel.ChannelName == channel.ChannelName {
+			s.admitMember(node)
+			s.announceAdmission(node)
+		}
+	}
+}
+
+// closeWaitlist turns away every node still waiting for our channel.
+func (s *Server) closeWaitlist() {
+	channel := &s.thisServer.Channel
+	waiting := channel.Waitlist
+	channel.Waitlist = nil
+	if !s.decidesJoins() {
+		return
+	}
+
+	for _, hashId := range waiting {
+		if node, exists := s.knownNodes[hashId]; exists {
+			s.rejectJoin(node, errors.New("the waitlist was closed"))
+		}
+	}
+}
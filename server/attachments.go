@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go-p2p/enum/headerType"
+	"go-p2p/model"
+	"go-p2p/profile"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sendAttachment posts a file to the current channel.
+func (s *Server) sendAttachment(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println("Error reading attachment:", err)
+		return
+	}
+
+	a := model.Attachment{Name: filepath.Base(path), Data: data}
+	s.postToChannel(model.Message{Type: headerType.ChatMessage, Content: a.Encode(), Attachment: true, Nickname: s.thisServer.Nickname, Timestamp: time.Now(), HashID: s.thisServer.HashID()})
+}
+
+// saveAttachment stores the newest attachment called name in the current
+// channel in the profile. Attachments are only written when asked for.
+func (s *Server) saveAttachment(name string) {
+	history := s.thisServer.Channel.ChatHistory
+	for i := len(history) - 1; i >= 0; i-- {
+		if !history[i].Attachment {
+			continue
+		}
+		a, err := model.DecodeAttachment(history[i].Text())
+		if err != nil || a.Name != name {
+			continue
+		}
+
+		path, err := s.writeAttachment(a)
+		if err != nil {
+			fmt.Println("Error saving attachment:", err)
+			return
+		}
+		fmt.Println("Saved to", path)
+		return
+	}
+	fmt.Println("No attachment called", name, "in this channel")
+}
+
+// writeAttachment creates a new file for a in the attachments directory.
+// The sender's file name is reduced to its base name and numbered when a
+// file of that name exists, so nothing is overwritten.
+func (s *Server) writeAttachment(a model.Attachment) (string, error) {
+	dir := s.profile.Path(profile.AttachmentsDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("error creating attachments directory: %v", err)
+	}
+
+	name := filepath.Base(strings.ReplaceAll(a.Name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		name = "attachment"
+	}
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	for n := 1; ; n++ {
+		path := filepath.Join(dir, name)
+		if n > 1 {
+			path = filepath.Join(dir, fmt.Sprintf("%s-%d%s", stem, n, ext))
+		}
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := file.Write(a.Data); err != nil {
+			file.Close()
+			return "", err
+		}
+		return path, file.Close()
+	}
+}
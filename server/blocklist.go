@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/model"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Ignored peers stay connected but their chat lines and private
+	// messages are dropped.
+	levelIgnore = "ignore"
+	// Blocked peers are also refused connections in both directions.
+	levelBlock = "block"
+)
+
+type blockEntry struct {
+	Nickname string    `json:"nickname"`
+	Level    string    `json:"level"`
+	Since    time.Time `json:"since"`
+}
+
+// blockList is the profile's blocklist.json, keyed by identity so a peer
+// cannot escape it by changing nickname.
+type blockList struct {
+	path    string
+	entries map[string]*blockEntry
+}
+
+func loadBlockList(path string) *blockList {
+	b := &blockList{path: path, entries: make(map[string]*blockEntry)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("Error reading block list:", err)
+		}
+		return b
+	}
+
+	if err := json.Unmarshal(data, &b.entries); err != nil {
+		fmt.Println("Error decoding block list:", err)
+	}
+	return b
+}
+
+func (b *blockList) save() {
+	data, err := json.MarshalIndent(b.entries, "", "  ")
+	if err != nil {
+		fmt.Println("Error encoding block list:", err)
+		return
+	}
+
+	if err := os.WriteFile(b.path, data, 0600); err != nil {
+		fmt.Println("Error writing block list:", err)
+	}
+}
+
+// ignores reports whether messages from hashId should be dropped.
+func (b *blockList) ignores(hashId string) bool {
+	_, exists := b.entries[hashId]
+	return exists
+}
+
+// refuses reports whether connections with hashId should be refused.
+func (b *blockList) refuses(hashId string) bool {
+	entry, exists := b.entries[hashId]
+	return exists && entry.Level == levelBlock
+}
+
+// resolvePeer finds the identity a block command refers to: a known node, or
+// a full fingerprint so peers that are offline can be blocked in advance.
+func (s *Server) resolvePeer(query string) (string, string, error) {
+	node, err := s.findNode(query)
+	if err == nil {
+		return node.HashID(), strings.TrimSpace(node.Nickname), nil
+	}
+
+	if model.IsFingerprint(query) {
+		return query, "", nil
+	}
+	return "", "", err
+}
+
+func (s *Server) blockPeer(query, level string) {
+	hashId, nickname, err := s.resolvePeer(query)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if hashId == s.thisServer.HashID() {
+		fmt.Println("You cannot", level, "yourself")
+		return
+	}
+
+	s.blocks.entries[hashId] = &blockEntry{Nickname: nickname, Level: level, Since: time.Now()}
+	s.blocks.save()
+
+	if level == levelBlock {
+		s.forgetNode(hashId)
+		fmt.Println("Blocked", query)
+	} else {
+		fmt.Println("Ignoring", query)
+	}
+}
+
+// unblockPeer removes an ignore or block by nickname, identity prefix or
+// fingerprint.
+func (s *Server) unblockPeer(query string) {
+	for hashId, entry := range s.blocks.entries {
+		if entry.Nickname == query || (len(query) >= 8 && strings.HasPrefix(hashId, query)) {
+			delete(s.blocks.entries, hashId)
+			s.blocks.save()
+			fmt.Println("No longer blocking", query)
+			return
+		}
+	}
+	fmt.Println("Not blocking anyone called", query)
+}
+
+func (s *Server) listBlocked() {
+	if len(s.blocks.entries) == 0 {
+		fmt.Println("Nobody is blocked or ignored")
+		return
+	}
+
+	ids := make([]string, 0, len(s.blocks.entries))
+	for hashId := range s.blocks.entries {
+		ids = append(ids, hashId)
+	}
+	sort.Strings(ids)
+
+	for _, hashId := range ids {
+		entry := s.blocks.entries[hashId]
+		fmt.Printf("%s  %-6s  %s\n", hashId[:16], entry.Level, entry.Nickname)
+	}
+}
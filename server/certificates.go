@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"go-p2p/certs"
+	"go-p2p/enum/headerType"
+	"go-p2p/model"
+	"net"
+	"strings"
+	"time"
+)
+
+// checkCertificate renews our certificate when it is within the renewal
+// window, swaps it into the TLS config and tells connected peers about it.
+func (s *Server) checkCertificate() {
+	leaf := s.thisServer.ID.Certificate().Leaf
+	if !certs.NeedsRenewal(leaf, certs.RenewalWindow) {
+		return
+	}
+
+	fmt.Println("Certificate expires at", leaf.NotAfter.Format(time.RFC1123), "- renewing")
+
+	certPEM, err := certs.RenewCert(s.profile.Dir, s.thisServer.ID.PrivateKey, s.thisServer.Hostname, s.thisServer.Port)
+	if errors.Is(err, certs.ErrCARenewalRequired) {
+		fmt.Println("WARNING: certificate expires at", leaf.NotAfter.Format(time.RFC1123)+":", err)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error renewing certificate:", err)
+		return
+	}
+
+	block, _ := pem.Decode(certPEM)
+	tlsCert := tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: s.thisServer.ID.PrivateKey}
+	tlsCert.Leaf, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		fmt.Println("Error parsing renewed certificate:", err)
+		return
+	}
+
+	s.thisServer.ID.SetCertificate(&tlsCert)
+	s.thisServer.ID.KnownPeers.Update(s.thisServer.HashID(), certs.CertFingerprint(block.Bytes))
+	fmt.Println("Certificate renewed, valid until", tlsCert.Leaf.NotAfter.Format(time.RFC1123))
+
+	s.announceCertificate(certPEM)
+}
+
+func (s *Server) announceCertificate(certPEM []byte) {
+	msg := model.Message{Type: headerType.CertUpdate, Hostname: s.thisServer.Hostname, Port: s.thisServer.Port, Content: string(certPEM), Nickname: s.thisServer.Nickname, Timestamp: time.Now(), HashID: s.thisServer.HashID()}
+
+	jsonData, err := s.encodeMessage(&msg)
+	if err != nil {
+		fmt.Println("Error encoding JSON:", err)
+		return
+	}
+
+	for _, node := range s.knownNodes {
+		node.Connection.Write(jsonData)
+	}
+}
+
+func (s *Server) startCertMonitor() {
+	ticker := time.NewTicker(12 * time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.checkCertificate()
+	}
+}
+
+// updatePeerCertificate re-pins a peer's renewed certificate. The update is
+// only trusted when it arrives over a connection that already authenticated
+// with the peer's current certificate and keeps the same public key.
+func (s *Server) updatePeerCertificate(incomingMsg model.Message, conn net.Conn) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok || len(tlsConn.ConnectionState().PeerCertificates) == 0 {
+		fmt.Println("Ignoring certificate update from unauthenticated connection")
+		return
+	}
+	current := tlsConn.ConnectionState().PeerCertificates[0]
+
+	block, _ := pem.Decode([]byte(incomingMsg.Content))
+	if block == nil || block.Type != "CERTIFICATE" {
+		fmt.Println("Invalid certificate update from", incomingMsg.Nickname)
+		return
+	}
+
+	renewed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		fmt.Println("Invalid certificate update from", incomingMsg.Nickname, err)
+		return
+	}
+
+	if !bytes.Equal(renewed.RawSubjectPublicKeyInfo, current.RawSubjectPublicKeyInfo) {
+		fmt.Println("Rejected certificate update from", incomingMsg.Nickname+": public key changed")
+		return
+	}
+
+	s.thisServer.ID.KnownPeers.Update(model.KeyFingerprint(current.RawSubjectPublicKeyInfo), certs.CertFingerprint(block.Bytes))
+}
+
+// checkBindings refuses node if its address or nickname was first seen with
+// another identity. The node is kept so /accept-peer can let it in.
+func (s *Server) checkBindings(node model.Node) error {
+	err := s.thisServer.ID.KnownPeers.Bind(node.HashID(), node.Address(), node.Nickname)
+	if err != nil {
+		certs.Warn(err)
+		s.refused[node.HashID()] = node
+	}
+	return err
+}
+
+// acceptPeer accepts the held certificate or binding changes of the identity
+// starting with prefix, and connects to the node if it was refused.
+func (s *Server) acceptPeer(prefix string) {
+	var matches []string
+	for _, identity := range s.thisServer.ID.KnownPeers.Pending() {
+		if strings.HasPrefix(identity, prefix) {
+			matches = append(matches, identity)
+		}
+	}
+	if len(matches) != 1 {
+		fmt.Println("No single pending change matches", prefix)
+		return
+	}
+
+	identity := matches[0]
+	if err := s.thisServer.ID.KnownPeers.Accept(identity); err != nil {
+		fmt.Println("Error accepting peer:", err)
+		return
+	}
+	fmt.Println("Accepted", identity)
+
+	if node, exists := s.refused[identity]; exists {
+		delete(s.refused, identity)
+		s.addNode(node)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/e2e"
+	"go-p2p/enum/headerType"
+	"go-p2p/model"
+	"strings"
+	"time"
+)
+
+// channelKeyring holds the shared key of an encrypted channel. The previous
+// epoch is kept so messages sent just before a rotation can still be read.
+type channelKeyring struct {
+	Epoch uint64
+	From  string
+	Keys  map[uint64][]byte
+}
+
+func (k *channelKeyring) set(epoch uint64, key []byte, from string) {
+	for old := range k.Keys {
+		if old+1 < epoch {
+			delete(k.Keys, old)
+		}
+	}
+	k.Epoch, k.From = epoch, from
+	k.Keys[epoch] = key
+}
+
+// channelKeyGrant is the content of a ChannelKey message: the channel key
+// sealed to the recipient's encryption key.
+type channelKeyGrant struct {
+	Epoch    uint64       `json:"epoch"`
+	Envelope e2e.Envelope `json:"envelope"`
+}
+
+func channelKeyAAD(channel string, epoch uint64, sender, recipient string) []byte {
+	return []byte(fmt.Sprintf("%s:%d:%s:%s", channel, epoch, sender, recipient))
+}
+
+func channelMessageAAD(channel, sender string) []byte {
+	return []byte(channel + ":" + sender)
+}
+
+// isChannelCoordinator reports whether this node has the lowest identity of
+// the members of its channel, ignoring exclude. Every member computes the
+// same answer, so exactly one of them distributes and rotates keys.
+func (s *Server) isChannelCoordinator(exclude string) bool {
+	self := s.thisServer.HashID()
+	for hashId := range s.thisServer.Channel.ConnectedNodes {
+		if hashId != exclude && hashId < self {
+			return false
+		}
+	}
+	return true
+}
+
+// generateChannelKey starts a new key epoch for the current channel and sends
+// it to every member.
+func (s *Server) generateChannelKey() {
+	channel := s.thisServer.Channel.ChannelName
+
+	key, err := e2e.NewGroupKey()
+	if err != nil {
+		fmt.Println("Error generating channel key:", err)
+		return
+	}
+
+	keyring, exists := s.channelKeys[channel]
+	if !exists {
+		keyring = &channelKeyring{Keys: make(map[uint64][]byte)}
+		s.channelKeys[channel] = keyring
+	}
+	keyring.set(keyring.Epoch+1, key, s.thisServer.HashID())
+
+	for hashId := range s.thisServer.Channel.ConnectedNodes {
+		if node, exists := s.knownNodes[hashId]; exists {
+			s.sendChannelKey(node)
+		}
+	}
+}
+
+func (s *Server) sendChannelKey(node *model.Node) {
+	channel := s.thisServer.Channel.ChannelName
+	keyring, exists := s.channelKeys[channel]
+	if !exists {
+		return
+	}
+
+	if err := node.VerifyEncryptionKey(); err != nil {
+		fmt.Printf("Cannot share channel key with %s: %v\n", strings.TrimSpace(node.Nickname), err)
+		return
+	}
+
+	env, err := e2e.Seal(node.EncryptionKey, keyring.Keys[keyring.Epoch], channelKeyAAD(channel, keyring.Epoch, s.thisServer.HashID(), node.HashID()))
+	if err != nil {
+		fmt.Println("Error sealing channel key:", err)
+		return
+	}
+
+	content, err := json.Marshal(channelKeyGrant{Epoch: keyring.Epoch, Envelope: env})
+	if err != nil {
+		fmt.Println("Error encoding channel key:", err)
+		return
+	}
+
+	msg := model.Message{Type: headerType.ChannelKey, Content: string(content), Nickname: s.thisServer.Nickname, Timestamp: time.Now(), HashID: s.thisServer.HashID(), Recipient: node.HashID(), Channel: channel}
+	jsonData, err := s.encodeMessage(&msg)
+	if err != nil {
+		fmt.Println("Error encoding JSON:", err)
+		return
+	}
+
+	node.Connection.Write(jsonData)
+}
+
+// receiveChannelKey accepts a channel key from a current member of our
+// channel. A newer epoch always wins; should two members hand out the same
+// epoch, the key from the lower identity is kept, matching the coordinator.
+func (s *Server) receiveChannelKey(incomingMsg model.Message) {
+	channel := s.thisServer.Channel
+	if incomingMsg.Recipient != s.thisServer.HashID() || incomingMsg.Channel != channel.ChannelName || !channel.Metadata.Settings.Encrypted {
+		return
+	}
+
+	if _, member := channel.ConnectedNodes[incomingMsg.HashID]; !member {
+		fmt.Println("Rejected channel key from non-member", strings.TrimSpace(incomingMsg.Nickname))
+		return
+	}
+
+	var grant channelKeyGrant
+	if err := json.Unmarshal([]byte(incomingMsg.Content), &grant); err != nil {
+		fmt.Println("Error decoding channel key:", err)
+		return
+	}
+
+	keyring, exists := s.channelKeys[channel.ChannelName]
+	if exists && (grant.Epoch < keyring.Epoch || (grant.Epoch == keyring.Epoch && incomingMsg.HashID >= keyring.From)) {
+		return
+	}
+
+	key, err := e2e.Open(s.thisServer.ID.EncryptionKey, grant.Envelope, channelKeyAAD(channel.ChannelName, grant.Epoch, incomingMsg.HashID, incomingMsg.Recipient))
+	if err != nil {
+		fmt.Println("Rejected channel key from", strings.TrimSpace(incomingMsg.Nickname)+":", err)
+		return
+	}
+
+	if !exists {
+		keyring = &channelKeyring{Keys: make(map[uint64][]byte)}
+		s.channelKeys[channel.ChannelName] = keyring
+	}
+	keyring.set(grant.Epoch, key, incomingMsg.HashID)
+}
+
+// channelMemberJoined hands the current key to a new member of our channel.
+func (s *Server) channelMemberJoined(hashId string) {
+	if !s.thisServer.Channel.Metadata.Settings.Encrypted || !s.isChannelCoordinator(hashId) {
+		return
+	}
+
+	if node, exists := s.knownNodes[hashId]; exists {
+		s.sendChannelKey(node)
+	}
+}
+
+// channelMemberLeft rotates the key of our channel once a member is gone, so
+// it cannot read anything sent afterwards.
+func (s *Server) channelMemberLeft() {
+	if !s.thisServer.Channel.Metadata.Settings.Encrypted || !s.isChannelCoordinator("") {
+		return
+	}
+
+	s.generateChannelKey()
+}
+
+// leaveChannelKeys forgets the key of the channel we are leaving.
+func (s *Server) leaveChannelKeys() {
+	delete(s.channelKeys, s.thisServer.Channel.ChannelName)
+}
+
+// sealChannelMessage encrypts msg for the current channel if it is encrypted.
+func (s *Server) sealChannelMessage(msg *model.Message) error {
+	channel := s.thisServer.Channel
+	msg.Channel = channel.ChannelName
+	if !channel.Metadata.Settings.Encrypted {
+		return nil
+	}
+
+	keyring, exists := s.channelKeys[channel.ChannelName]
+	if !exists {
+		return errors.New("no key for this channel yet")
+	}
+
+	sealed, err := e2e.SealGroup(keyring.Keys[keyring.Epoch], keyring.Epoch, []byte(msg.Content), channelMessageAAD(channel.ChannelName, msg.HashID))
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(sealed)
+	if err != nil {
+		return err
+	}
+	msg.Content = string(content)
+	return nil
+}
+
+// openChannelMessage decrypts a chat message in an encrypted channel into
+// its Plaintext, leaving the signed ciphertext in Content. Plaintext messages
+// are refused in encrypted channels.
+func (s *Server) openChannelMessage(msg *model.Message) error {
+	msg.Plaintext = ""
+	channel, exists := s.channels[msg.Channel]
+	if msg.Channel == s.thisServer.Channel.ChannelName {
+		channel, exists = &s.thisServer.Channel, true
+	}
+	if !exists || !channel.Metadata.Settings.Encrypted {
+		return nil
+	}
+
+	var sealed e2e.GroupMessage
+	if err := json.Unmarshal([]byte(msg.Content), &sealed); err != nil {
+		return errors.New("unencrypted message in encrypted channel")
+	}
+
+	keyring, exists := s.channelKeys[msg.Channel]
+	if !exists || keyring.Keys[sealed.Epoch] == nil {
+		return errors.New("no key for this message")
+	}
+
+	plaintext, err := e2e.OpenGroup(keyring.Keys[sealed.Epoch], sealed, channelMessageAAD(msg.Channel, msg.HashID))
+	if err != nil {
+		return err
+	}
+	msg.Plaintext = string(plaintext)
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"go-p2p/model"
+	"strings"
+)
+
+// findNode resolves a peer by nickname or by a prefix of its identity.
+func (s *Server) findNode(query string) (*model.Node, error) {
+	var matches []*model.Node
+	for hashId, node := range s.knownNodes {
+		if strings.TrimSpace(node.Nickname) == query || (len(query) >= 8 && strings.HasPrefix(hashId, query)) {
+			matches = append(matches, node)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no peer called %s", query)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("%s is ambiguous, use an identity prefix instead", query)
+	}
+}
+
+// argsAfter returns the rest of a command line after its first n words.
+func argsAfter(line string, n int) string {
+	rest := line
+	for i := 0; i < n; i++ {
+		rest = strings.TrimLeft(rest, " \t")
+		idx := strings.IndexAny(rest, " \t")
+		if idx < 0 {
+			return ""
+		}
+		rest = rest[idx:]
+	}
+	return strings.TrimSpace(rest)
+}
+
+func (s *Server) handleCommand(line string) {
+	fields := strings.Fields(line)
+
+	switch fields[0] {
+	case "/msg":
+		if len(fields) < 3 {
+			fmt.Println("Usage: /msg <nickname|id> <message>")
+			return
+		}
+
+		node, err := s.findNode(fields[1])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		text := argsAfter(line, 2)
+		if err := s.sendPrivateMessage(text, node.HashID()); err != nil {
+			fmt.Println("Error sending private message:", err)
+		}
+	case "/create":
+		s.createCommand(fields, line)
+	case "/join":
+		if len(fields) < 2 {
+			fmt.Println("Usage: /join <channel> [password|invite]")
+			return
+		}
+
+		if _, exists := s.channels[fields[1]]; !exists {
+			fmt.Println("Channel not found:", fields[1])
+			return
+		}
+		s.ChangeChannel(fields[1], argsAfter(line, 2))
+	case "/invite":
+		s.inviteCommand(fields)
+	case "/safety":
+		if len(fields) == 1 {
+			s.listVerified()
+			return
+		}
+
+		node, err := s.findNode(fields[1])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		s.showSafetyNumber(node)
+	case "/verify":
+		if len(fields) != 2 {
+			fmt.Println("Usage: /verify <nickname|id>")
+			return
+		}
+
+		node, err := s.findNode(fields[1])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		s.verifyContact(node)
+	case "/accept-peer":
+		if len(fields) != 2 {
+			fmt.Println("Usage: /accept-peer <identity>")
+			return
+		}
+		s.acceptPeer(fields[1])
+	case "/unverify":
+		if len(fields) != 2 {
+			fmt.Println("Usage: /unverify <nickname|id>")
+			return
+		}
+		s.unverifyContact(fields[1])
+	case "/ignore", "/block":
+		if len(fields) != 2 {
+			fmt.Printf("Usage: %s <nickname|id|fingerprint>\n", fields[0])
+			return
+		}
+		s.blockPeer(fields[1], strings.TrimPrefix(fields[0], "/"))
+	case "/unblock":
+		if len(fields) != 2 {
+			fmt.Println("Usage: /unblock <nickname|id>")
+			return
+		}
+		s.unblockPeer(fields[1])
+	case "/blocked":
+		s.listBlocked()
+	case "/revoke":
+		if len(fields) < 2 || fields[1] != "confirm" {
+			fmt.Println("This permanently revokes this node's identity on every peer and mirror.")
+			fmt.Println("Usage: /revoke confirm [reason]")
+			return
+		}
+		s.revokeSelf(argsAfter(line, 2))
+	case "/revocation-cert":
+		if len(fields) < 2 {
+			fmt.Println("Usage: /revocation-cert <file> [reason]")
+			return
+		}
+		s.writeRevocationCert(fields[1], argsAfter(line, 2))
+	case "/publish-revocation":
+		if len(fields) != 2 {
+			fmt.Println("Usage: /publish-revocation <file>")
+			return
+		}
+		s.publishRevocationCert(fields[1])
+	case "/members":
+		s.listMembers()
+	case "/kick", "/ban", "/unban", "/mute", "/unmute", "/role":
+		s.moderationCommand(fields)
+	case "/topic", "/describe", "/settings", "/set", "/limit", "/waitlist":
+		s.metadataCommand(fields, line)
+	case "/attach":
+		if len(fields) < 2 {
+			fmt.Println("Usage: /attach <file>")
+			return
+		}
+		s.sendAttachment(argsAfter(line, 1))
+	case "/save":
+		if len(fields) < 2 {
+			fmt.Println("Usage: /save <attachment name>")
+			return
+		}
+		s.saveAttachment(argsAfter(line, 1))
+	case "/channels":
+		s.listChannels()
+	case "/history":
+		s.historyCommand(fields)
+	case "/help":
+		fmt.Println("/msg <nickname|id> <message>   send an end-to-end encrypted private message")
+		fmt.Println("/create <channel> [options]    create a channel: encrypted, invite, password <password>")
+		fmt.Println("/join <channel> [credential]   move into a channel, with its password or an invite")
+		fmt.Println("/invite <nickname|anyone> [h]  invite to this channel, valid for h hours (operators)")
+		fmt.Println("/safety [nickname|id]          show the safety number of a peer, or list verified contacts")
+		fmt.Println("/verify <nickname|id>          mark a peer as verified after comparing safety numbers")
+		fmt.Println("/unverify <nickname|id>        remove a verification")
+		fmt.Println("/accept-peer <identity>        accept a peer refused over a changed key or certificate")
+		fmt.Println("/ignore <nickname|id>          drop a peer's chat lines and private messages")
+		fmt.Println("/block <nickname|id>           ignore a peer and refuse its connections")
+		fmt.Println("/unblock <nickname|id>         stop ignoring or blocking a peer")
+		fmt.Println("/blocked                       list ignored and blocked peers")
+		fmt.Println("/revoke confirm [reason]       revoke this node's identity everywhere and stop")
+		fmt.Println("/revocation-cert <file>        write a revocation to publish if this key is ever lost")
+		fmt.Println("/publish-revocation <file>     publish a revocation certificate")
+		fmt.Println("/members                       list the members of this channel and their roles")
+		fmt.Println("/channels                      list channels with their topic and creator")
+		fmt.Println("/topic <text>                  set the channel topic (operators)")
+		fmt.Println("/describe <text>               set the channel description (operators)")
+		fmt.Println("/kick|/ban|/unban <nickname>   remove or ban a member (operators)")
+		fmt.Println("/mute|/unmute <nickname>       stop or allow a member's messages (operators)")
+		fmt.Println("/role <nickname> <role>        grant operator, voiced or member")
+		fmt.Println("/settings                      show the settings of this channel")
+		fmt.Println("/set <setting> <value>         change a channel setting (owner), see /settings")
+		fmt.Println("/limit <members>               set the member limit of this channel (owner)")
+		fmt.Println("/waitlist on|off               queue joiners while the channel is full (owner)")
+		fmt.Println("/attach <file>                 send a small file to the channel")
+		fmt.Println("/save <attachment name>        save the latest attachment of that name in this channel")
+		fmt.Println("/history <index> <value>       search stored messages: channel, from, pm or last <hours>")
+		fmt.Println("EXIT                           stop reading input")
+	default:
+		fmt.Println("Unknown command, try /help")
+	}
+}
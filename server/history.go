@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/enum/headerType"
+	"go-p2p/history"
+	"go-p2p/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// historyPageMessages caps the messages in one history page.
+	historyPageMessages = 50
+	// historyPeers is how many members a joiner syncs history from, so a
+	// gap in one member's history is filled from another.
+	historyPeers = 3
+)
+
+// frameLimit is the largest message both this node and a peer with the
+// given limit accept. A peer bans whoever sends it more than its limit.
+func (s *Server) frameLimit(peerLimit int) int {
+	limit := s.profile.Settings.Limits.MaxMessageBytes
+	if peerLimit > 0 && peerLimit < limit {
+		limit = peerLimit
+	}
+	return limit
+}
+
+// syncHistory starts fetching the history of our channel from a member that
+// let us in, beginning after the newest message we hold.
+func (s *Server) syncHistory(hashId string) {
+	channel := &s.thisServer.Channel
+	if channel.HistoryPeers[hashId] || len(channel.HistoryPeers) >= historyPeers {
+		return
+	}
+
+	node, exists := s.knownNodes[hashId]
+	if !exists {
+		return
+	}
+
+	if channel.HistoryPeers == nil {
+		channel.HistoryPeers = make(map[string]bool)
+	}
+	channel.HistoryPeers[hashId] = true
+	s.requestHistory(node, channel.Cursor())
+}
+
+func (s *Server) requestHistory(node *model.Node, after model.HistoryCursor) {
+	content, err := json.Marshal(model.HistoryRequest{Channel: s.thisServer.Channel.ChannelName, After: after, MaxBytes: s.profile.Settings.Limits.MaxMessageBytes})
+	if err != nil {
+		fmt.Println("Error encoding history request:", err)
+		return
+	}
+
+	msg := model.Message{Type: headerType.HistoryRequest, Content: string(content), Nickname: s.thisServer.Nickname, Timestamp: time.Now(), HashID: s.thisServer.HashID()}
+	jsonData, err := s.encodeMessage(&msg)
+	if err != nil {
+		fmt.Println("Error encoding JSON:", err)
+		return
+	}
+	node.Connection.Write(jsonData)
+}
+
+// receiveHistoryRequest answers a member of our channel with the next page
+// of history after its cursor.
+func (s *Server) receiveHistoryRequest(incomingMsg model.Message) {
+	var req model.HistoryRequest
+	if err := json.Unmarshal([]byte(incomingMsg.Content), &req); err != nil {
+		fmt.Println("Error decoding history request:", err)
+		return
+	}
+
+	channel := &s.thisServer.Channel
+	if req.Channel != channel.ChannelName || !isMember(channel, incomingMsg.HashID) {
+		return
+	}
+	node, exists := s.knownNodes[incomingMsg.HashID]
+	if !exists {
+		return
+	}
+
+	channel.Prune(time.Now())
+
+	// The page travels as the escaped content of a message, so it starts at
+	// half the frame limit and shrinks until the message fits.
+	limit := s.frameLimit(req.MaxBytes)
+	for budget := limit / 2; ; budget /= 2 {
+		page := channel.HistoryAfter(req.After, historyPageMessages, budget)
+
+		content, err := json.Marshal(page)
+		if err != nil {
+			fmt.Println("Error encoding history page:", err)
+			return
+		}
+
+		msg := model.Message{Type: headerType.HistoryPage, Content: string(content), Nickname: s.thisServer.Nickname, Timestamp: time.Now(), HashID: s.thisServer.HashID()}
+		jsonData, err := s.encodeMessage(&msg)
+		if err != nil {
+			fmt.Println("Error encoding JSON:", err)
+			return
+		}
+
+		if len(jsonData) <= limit {
+			node.Connection.Write(jsonData)
+			return
+		}
+		if len(page.Messages) <= 1 {
+			fmt.Println("History page for", strings.TrimSpace(node.Nickname), "does not fit the size limit, not sent")
+			return
+		}
+	}
+}
+
+// receiveHistoryPage merges a page from a member we asked and asks for the
+// next one while there is more. Messages that would not pass as live chat
+// messages are dropped.
+func (s *Server) receiveHistoryPage(incomingMsg model.Message) {
+	var page model.HistoryPage
+	if err := json.Unmarshal([]byte(incomingMsg.Content), &page); err != nil {
+		fmt.Println("Error decoding history page:", err)
+		return
+	}
+
+	channel := &s.thisServer.Channel
+	if page.Channel != channel.ChannelName || !channel.HistoryPeers[incomingMsg.HashID] {
+		return
+	}
+
+	accepted := make([]model.Message, 0, len(page.Messages))
+	for _, msg := range page.Messages {
+		if s.checkHistoryMessage(&msg) == nil {
+			accepted = append(accepted, msg)
+		}
+	}
+	if dropped := len(page.Messages) - len(accepted); dropped > 0 {
+		fmt.Printf("Dropped %d messages from the history sent by %s\n", dropped, strings.TrimSpace(incomingMsg.Nickname))
+	}
+
+	for _, msg := range channel.MergeHistory(accepted) {
+		fmt.Print(msg.PrintMessage())
+		s.storeMessage(channel, "", msg)
+	}
+	channel.Prune(time.Now())
+
+	if node, exists := s.knownNodes[incomingMsg.HashID]; exists && page.More && len(page.Messages) > 0 {
+		s.requestHistory(node, page.Next)
+	}
+}
+
+// checkHistoryMessage holds a message from a history page to what a live
+// chat message must pass: its sender's signature, our blocklist, and the
+// channel settings applied to the text its ciphertext decrypts to.
+func (s *Server) checkHistoryMessage(msg *model.Message) error {
+	if msg.Type != headerType.ChatMessage {
+		return fmt.Errorf("%s message in history", msg.Type)
+	}
+	if err := s.verifyMessage(*msg); err != nil {
+		return err
+	}
+	if s.blocks.ignores(msg.HashID) {
+		return errors.New("sender is ignored")
+	}
+	if err := s.openChannelMessage(msg); err != nil {
+		return err
+	}
+	return s.thisServer.Channel.CheckHistory(*msg)
+}
+
+// storeMessage appends a message to the history on disk. Channel messages
+// are kept under their channel's name and creation, private messages under
+// the peer they were exchanged with.
+func (s *Server) storeMessage(channel *model.Channel, peer string, msg model.Message) {
+	e := history.Entry{Peer: peer, Message: msg}
+	if channel != nil {
+		e.Channel, e.Creation = channel.ChannelName, channel.Metadata.Creation()
+	}
+	if err := s.history.Append(e); err != nil {
+		fmt.Println("Error storing message:", err)
+	}
+}
+
+// restoreHistory loads the private conversations and the history of our
+// current channel from disk at startup.
+func (s *Server) restoreHistory() {
+	for _, peer := range s.history.Peers() {
+		s.privateMessageHistory[peer] = s.history.Conversation(peer)
+	}
+	s.loadChannelHistory(&s.thisServer.Channel)
+}
+
+// loadChannelHistory fills channel with the messages stored for it, so a
+// later sync only asks members for what came after them.
+func (s *Server) loadChannelHistory(channel *model.Channel) {
+	s.expireHistory(channel)
+	channel.MergeHistory(s.history.Channel(channel.ChannelName, channel.Metadata.Creation()))
+	channel.Prune(time.Now())
+}
+
+// expireHistory removes stored messages of channel that its retention
+// setting no longer keeps.
+func (s *Server) expireHistory(channel *model.Channel) {
+	retention := channel.Metadata.Settings.Retention
+	if retention <= 0 {
+		return
+	}
+
+	cutoff := time.Now().Add(-time.Duration(retention) * time.Hour)
+	if err := s.history.Expire(channel.ChannelName, channel.Metadata.Creation(), cutoff); err != nil {
+		fmt.Println("Error expiring history:", err)
+	}
+}
+
+func (s *Server) printEntries(entries []history.Entry) {
+	if len(entries) == 0 {
+		fmt.Println("No stored messages")
+		return
+	}
+
+	for _, e := range entries {
+		where := "#" + e.Channel
+		if e.Peer != "" {
+			where = "PM " + s.nicknameOf(e.Peer)
+		}
+		line := strings.TrimRight(e.Message.PrintMessage(), "\n")
+		fmt.Printf("[%s] %s %s\n", where, e.Message.Timestamp.Local().Format("2006-01-02"), line)
+	}
+}
+
+// historyCommand parses /history channel <name> | from <peer> | pm <peer> |
+// last <hours>.
+func (s *Server) historyCommand(fields []string) {
+	usage := "Usage: /history channel <channel> | from <nickname|id> | pm <nickname|id> | last <hours>"
+	if len(fields) != 3 {
+		fmt.Println(usage)
+		return
+	}
+
+	var entries []history.Entry
+	switch fields[1] {
+	case "channel":
+		entries = s.history.ChannelNamed(fields[2])
+	case "from", "pm":
+		hashId, _, err := s.resolvePeer(fields[2])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if fields[1] == "from" {
+			entries = s.history.From(hashId)
+			break
+		}
+		for _, msg := range s.history.Conversation(hashId) {
+			entries = append(entries, history.Entry{Peer: hashId, Message: msg})
+		}
+	case "last":
+		hours, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || hours <= 0 {
+			fmt.Println("Invalid number of hours:", fields[2])
+			return
+		}
+		now := time.Now()
+		entries = s.history.Between(now.Add(-time.Duration(hours*float64(time.Hour))), now)
+	default:
+		fmt.Println(usage)
+		return
+	}
+	s.printEntries(entries)
+}
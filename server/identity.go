@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"go-p2p/certs"
+	"go-p2p/e2e"
+	"go-p2p/enum/keyAlgorithm"
+	"go-p2p/model"
+	"go-p2p/profile"
+	"go-p2p/utils"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// passphraseEnv lets scripts and agents unlock the key without a prompt.
+const passphraseEnv = "GOP2P_KEY_PASSPHRASE"
+
+// keyUnlocker supplies the profile's key passphrase: from a key file if
+// given, then the environment, then an interactive prompt. The passphrase is
+// asked for at most once and reused for the other keys in the profile.
+type keyUnlocker struct {
+	file  string
+	value []byte
+	known bool
+	// plaintext allows a new key to be written without a passphrase.
+	plaintext bool
+}
+
+func (u *keyUnlocker) passphrase() ([]byte, error) {
+	if u.known {
+		return u.value, nil
+	}
+
+	var pass []byte
+	if u.file != "" {
+		data, err := os.ReadFile(u.file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading passphrase file: %v", err)
+		}
+		pass = []byte(strings.TrimRight(string(data), "\r\n"))
+	} else if env, ok := os.LookupEnv(passphraseEnv); ok {
+		pass = []byte(env)
+	} else {
+		var err error
+		pass, err = utils.ReadPassphrase("Key passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	u.value, u.known = pass, true
+	return pass, nil
+}
+
+var errPassphraseRequired = errors.New("a new key needs a passphrase, or run with -insecure-plaintext-key to store it unencrypted")
+
+// newPassphrase asks for the passphrase of a key about to be created. The
+// key is only stored unencrypted when that was explicitly allowed.
+func (u *keyUnlocker) newPassphrase() ([]byte, error) {
+	if _, ok := os.LookupEnv(passphraseEnv); u.file != "" || ok {
+		pass, err := u.passphrase()
+		if err == nil && len(pass) == 0 && !u.plaintext {
+			return nil, errPassphraseRequired
+		}
+		return pass, err
+	}
+
+	prompt := "Passphrase for new key: "
+	if u.plaintext {
+		prompt = "Passphrase for new key (empty for none): "
+	}
+	pass, err := utils.ReadPassphrase(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 && !u.plaintext {
+		return nil, errPassphraseRequired
+	}
+
+	confirm, err := utils.ReadPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(pass, confirm) {
+		return nil, errors.New("passphrases do not match")
+	}
+
+	u.value, u.known = pass, true
+	return pass, nil
+}
+
+// loadEncryptionKey loads the profile's X25519 key used for end-to-end
+// encryption, creating it on first use. It is protected like the identity
+// key: encrypted with the same passphrase if that key is encrypted.
+func loadEncryptionKey(p *profile.Profile, unlock *keyUnlocker) (*ecdh.PrivateKey, error) {
+	path := p.Path(profile.EncryptionKeyFile)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		key, err := e2e.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := certs.WritePrivateKey(path, key, unlock.value); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+
+	return certs.LoadX25519Key(path, unlock.passphrase)
+}
+
+// legacyCertsDir is where identities lived before profiles. Its files are
+// adopted by the default profile.
+var legacyCertsDir = filepath.Join("..", "certs")
+
+// migrateLegacyIdentity moves a pre-profile identity into the default
+// profile. Those keys were stored as plaintext PKCS#1, so the moved key is
+// re-encrypted with a new passphrase unless plaintext keys are allowed.
+func migrateLegacyIdentity(p *profile.Profile, unlock *keyUnlocker) {
+	if p.Name != profile.DefaultName {
+		return
+	}
+	if _, err := os.Stat(p.Path(profile.KeyFile)); !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(legacyCertsDir, profile.KeyFile)); err != nil {
+		return
+	}
+
+	fmt.Println("Moving identity from", legacyCertsDir, "into profile", p.Name)
+	for _, file := range []string{profile.KeyFile, profile.CertFile, profile.KnownPeersFile, certs.CAFile} {
+		if err := os.Rename(filepath.Join(legacyCertsDir, file), p.Path(file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("Error moving", file+":", err)
+		}
+	}
+
+	path := p.Path(profile.KeyFile)
+	key, err := certs.LoadPrivateKey(path, unlock.passphrase)
+	if err != nil {
+		fmt.Println("Error reading moved identity key:", err)
+		return
+	}
+
+	fmt.Println("The moved identity key is not encrypted")
+	pass, err := unlock.newPassphrase()
+	if err != nil {
+		fmt.Println("WARNING: identity key left unencrypted in", path+":", err)
+		return
+	}
+	if len(pass) == 0 {
+		fmt.Println("WARNING: identity key left unencrypted in", path)
+		return
+	}
+
+	if err := certs.WritePrivateKey(path, key, pass); err != nil {
+		fmt.Println("WARNING: identity key left unencrypted in", path+":", err)
+	}
+}
+
+func generateIdentification(p *profile.Profile, host, port string, alg keyAlgorithm.Algorithm, passphraseFile string, plaintextKey bool) (*model.Identification, error) {
+	unlock := &keyUnlocker{file: passphraseFile, plaintext: plaintextKey}
+
+	migrateLegacyIdentity(p, unlock)
+
+	if _, err := os.Stat(p.Path(profile.KeyFile)); errors.Is(err, os.ErrNotExist) {
+		pass, err := unlock.newPassphrase()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := certs.GenerateCert(p.Dir, host, port, alg, pass); err != nil {
+			return nil, err
+		}
+	}
+
+	pk, err := certs.LoadPrivateKey(p.Path(profile.KeyFile), unlock.passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := certs.LoadCertificate(p.Path(profile.CertFile))
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(pk.Public())
+	if err != nil || !bytes.Equal(pub, leaf.RawSubjectPublicKeyInfo) {
+		return nil, errors.New("cert.pem does not belong to key.pem")
+	}
+
+	tlsCert := tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: pk, Leaf: leaf}
+
+	knownPeers, err := certs.LoadKnownPeers(p.Path(profile.KnownPeersFile))
+	if err != nil {
+		return nil, err
+	}
+
+	caPool, err := certs.LoadCABundle(p.Path(certs.CAFile))
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionKey, err := loadEncryptionKey(p, unlock)
+	if err != nil {
+		return nil, err
+	}
+
+	id := &model.Identification{PrivateKey: pk, EncryptionKey: encryptionKey, KnownPeers: knownPeers, CAPool: caPool}
+	id.SetCertificate(&tlsCert)
+
+	// Peers use self-signed certificates, so chain verification is replaced
+	// by pinning each peer's certificate on first contact. Certificates are
+	// looked up per handshake so renewals apply to new connections.
+	tlsConfig := &tls.Config{
+		GetCertificate:        id.GetCertificate,
+		GetClientCertificate:  id.GetClientCertificate,
+		MinVersion:            tls.VersionTLS12,
+		ClientAuth:            tls.RequireAnyClientCert,
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: knownPeers.VerifyPeer(),
+	}
+
+	// With a CA bundle only certificates issued by the network CA are
+	// accepted, in both directions.
+	if caPool != nil {
+		fmt.Println("CA bundle found, only CA-issued nodes may connect")
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.ClientCAs = caPool
+		tlsConfig.VerifyPeerCertificate = certs.VerifyChain(caPool)
+	}
+
+	id.Config = tlsConfig
+	return id, nil
+}
+
+// signedEncryptionKey returns our X25519 public key and the identity key's
+// signature over it, so peers can tell the key really is ours.
+func signedEncryptionKey(id *model.Identification) ([]byte, []byte) {
+	key := id.EncryptionKey.PublicKey().Bytes()
+
+	sig, err := certs.Sign(id.PrivateKey, model.EncryptionKeyDigest(key))
+	if err != nil {
+		fmt.Println("Error signing encryption key:", err)
+		return nil, nil
+	}
+
+	return key, sig
+}
+
+func publicKeyBytes(id *model.Identification) []byte {
+	der, err := x509.MarshalPKIXPublicKey(id.PrivateKey.Public())
+	if err != nil {
+		fmt.Println("Error encoding public key:", err)
+		return nil
+	}
+
+	return der
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/enum/headerType"
+	"go-p2p/profile"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+var errFrameTooLarge = errors.New("message exceeds size limit")
+
+// frameReader stops the decoder from reading more than the size limit past
+// the end of the last complete message, so an oversized message is rejected
+// before it is ever buffered in full.
+type frameReader struct {
+	r     io.Reader
+	read  int64
+	limit int64
+}
+
+func (f *frameReader) Read(p []byte) (int, error) {
+	if f.read >= f.limit {
+		return 0, errFrameTooLarge
+	}
+	if remaining := f.limit - f.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := f.r.Read(p)
+	f.read += int64(n)
+	return n, err
+}
+
+// nextFrame allows max bytes for the message after the one decoder just
+// returned. Bytes the decoder already buffered count towards it.
+func (f *frameReader) nextFrame(decoder *json.Decoder, max int) {
+	buffered, _ := io.Copy(io.Discard, decoder.Buffered())
+	f.limit = f.read - buffered + int64(max)
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per peer and message type, and the peers
+// that are temporarily banned for exceeding a limit.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limits  profile.Limits
+	buckets map[string]map[headerType.Type]*tokenBucket
+	bans    map[string]time.Time
+}
+
+func newRateLimiter(limits profile.Limits) *rateLimiter {
+	return &rateLimiter{
+		limits:  limits,
+		buckets: make(map[string]map[headerType.Type]*tokenBucket),
+		bans:    make(map[string]time.Time),
+	}
+}
+
+// allow takes a token from the peer's bucket for t. Types without a rate of
+// their own share one bucket, so unknown types cannot grow the table.
+func (rl *rateLimiter) allow(peerID string, t headerType.Type) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if _, exists := rl.limits.Rates[string(t)]; !exists {
+		t = "*"
+	}
+	rate := rl.limits.RateFor(t)
+
+	peerBuckets, exists := rl.buckets[peerID]
+	if !exists {
+		peerBuckets = make(map[headerType.Type]*tokenBucket)
+		rl.buckets[peerID] = peerBuckets
+	}
+
+	now := time.Now()
+	bucket, exists := peerBuckets[t]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(rate.Burst), last: now}
+		peerBuckets[t] = bucket
+	}
+
+	bucket.tokens = math.Min(float64(rate.Burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rate.PerSecond)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+func (rl *rateLimiter) ban(peerID string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.bans[peerID] = time.Now().Add(rl.limits.BanDuration())
+	delete(rl.buckets, peerID)
+}
+
+func (rl *rateLimiter) banned(peerID string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	until, exists := rl.bans[peerID]
+	if exists && time.Now().After(until) {
+		delete(rl.bans, peerID)
+		return false
+	}
+	return exists
+}
+
+// penalize bans a peer that exceeded a limit; the caller closes the
+// connection.
+func (s *Server) penalize(peerID, address, reason string) {
+	s.limiter.ban(peerID)
+	fmt.Printf("Disconnected %s: %s, banned for %s\n", address, reason, s.limiter.limits.BanDuration())
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/enum/headerType"
+	"go-p2p/model"
+)
+
+// encodeMessage stamps msg with a fresh nonce, signs it as its author and
+// encodes it for the wire.
+func (s *Server) encodeMessage(msg *model.Message) ([]byte, error) {
+	msg.Nonce = newNonce()
+	if err := msg.Sign(s.thisServer.ID.PrivateKey); err != nil {
+		return nil, err
+	}
+	return json.Marshal(msg)
+}
+
+// senderKey looks up the public key of the node a message claims to be from.
+// Only a NewNode handshake may introduce a key we have not seen yet.
+func (s *Server) senderKey(msg model.Message) ([]byte, error) {
+	if msg.HashID == s.thisServer.HashID() {
+		return s.thisServer.PublicKey, nil
+	}
+
+	if node, exists := s.knownNodes[msg.HashID]; exists && len(node.PublicKey) > 0 {
+		return node.PublicKey, nil
+	}
+
+	if msg.Type == headerType.NewNode && len(msg.PublicKey) > 0 && model.KeyFingerprint(msg.PublicKey) == msg.HashID {
+		return msg.PublicKey, nil
+	}
+
+	return nil, errors.New("unknown sender")
+}
+
+// connectionBound reports whether a message type describes the connection it
+// arrives on, so it can never be relayed on someone else's behalf.
+func connectionBound(t headerType.Type) bool {
+	return t == headerType.NewNode || t == headerType.CertUpdate
+}
+
+// authenticate checks a message against the identity proven by the TLS
+// handshake of the connection it arrived on. Messages from the peer itself
+// are verified with its certificate key; messages claiming another author are
+// treated as relayed and must carry that author's valid signature.
+//
+// A message is only accepted once: its signed nonce and timestamp are checked
+// against the replay cache after the signature.
+func (s *Server) authenticate(msg model.Message, peerID string, peerKey []byte) error {
+	if s.revocations.Revoked(msg.HashID) {
+		return fmt.Errorf("%s message from revoked identity %s", msg.Type, msg.HashID)
+	}
+
+	if msg.HashID == peerID {
+		if err := msg.Verify(peerKey); err != nil {
+			return fmt.Errorf("%s message from %s: %v", msg.Type, peerID, err)
+		}
+	} else if connectionBound(msg.Type) {
+		return fmt.Errorf("%s message for %s sent by %s", msg.Type, msg.HashID, peerID)
+	} else if err := s.verifyMessage(msg); err != nil {
+		return err
+	}
+
+	if err := s.replays.check(msg.HashID, msg.Nonce, msg.Timestamp); err != nil {
+		return fmt.Errorf("%s message from %s: %v", msg.Type, msg.HashID, err)
+	}
+	return nil
+}
+
+func (s *Server) verifyMessage(msg model.Message) error {
+	publicKey, err := s.senderKey(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := msg.Verify(publicKey); err != nil {
+		return fmt.Errorf("%s message from %s: %v", msg.Type, msg.HashID, err)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/enum/headerType"
+	"go-p2p/enum/role"
+	"go-p2p/model"
+	"sort"
+	"strings"
+	"time"
+)
+
+// foundChannel records who created a channel, on the word of the creator
+// itself: the node announcing the channel must be the one that signed its
+// record. The lobby has no creator.
+func (s *Server) foundChannel(m model.ChannelMetadata, announcer string) error {
+	if m.Channel == defaultChannelName {
+		return errors.New("the lobby has no creator")
+	}
+	if m.Creator != announcer {
+		return errors.New("not announced by its creator")
+	}
+
+	for _, channel := range s.channelCopies(m.Channel) {
+		if channel.Creator() != "" {
+			continue
+		}
+		if err := channel.Found(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyMetadata merges m into every copy of its channel. The first copy
+// decides whether the update is accepted; its metadata before and after the
+// merge is returned.
+func (s *Server) applyMetadata(m model.ChannelMetadata) (previous, current model.ChannelMetadata, err error) {
+	if m.Channel == defaultChannelName {
+		return previous, current, errors.New("the lobby has no metadata")
+	}
+
+	copies := s.channelCopies(m.Channel)
+	if len(copies) == 0 {
+		return previous, current, fmt.Errorf("unknown channel %s", m.Channel)
+	}
+
+	previous = copies[0].Metadata
+	if _, err := copies[0].MergeMetadata(m); err != nil {
+		return previous, previous, err
+	}
+	for _, channel := range copies[1:] {
+		channel.MergeMetadata(m)
+	}
+	return previous, copies[0].Metadata, nil
+}
+
+// publishMetadata applies an update made on this node and sends it to every
+// known node.
+func (s *Server) publishMetadata(m model.ChannelMetadata) {
+	previous, current, err := s.applyMetadata(m)
+	if err != nil {
+		fmt.Println("Not allowed:", err)
+		return
+	}
+
+	content, err := json.Marshal(m)
+	if err != nil {
+		fmt.Println("Error encoding channel metadata:", err)
+		return
+	}
+
+	msg := model.Message{Type: headerType.Metadata, Content: string(content), Nickname: s.thisServer.Nickname, Timestamp: time.Now(), HashID: s.thisServer.HashID(), Channel: m.Channel}
+	jsonData, err := s.encodeMessage(&msg)
+	if err != nil {
+		fmt.Println("Error encoding JSON:", err)
+		return
+	}
+
+	for _, node := range s.knownNodes {
+		node.Connection.Write(jsonData)
+	}
+
+	s.metadataEffects(previous, current)
+}
+
+func (s *Server) receiveMetadata(incomingMsg model.Message) {
+	var m model.ChannelMetadata
+	if err := json.Unmarshal([]byte(incomingMsg.Content), &m); err != nil {
+		fmt.Println("Error decoding channel metadata:", err)
+		return
+	}
+
+	previous, current, err := s.applyMetadata(m)
+	if err != nil {
+		if !errors.Is(err, model.ErrStaleMetadata) {
+			fmt.Println("Rejected channel metadata from", strings.TrimSpace(incomingMsg.Nickname)+":", err)
+		}
+		return
+	}
+
+	s.metadataEffects(previous, current)
+}
+
+// metadataEffects reports what changed in our current channel and carries
+// out changed settings.
+func (s *Server) metadataEffects(previous, current model.ChannelMetadata) {
+	if current.Channel != s.thisServer.Channel.ChannelName {
+		return
+	}
+
+	if current.Details.UpdatedAt.After(previous.Details.UpdatedAt) {
+		author := s.nicknameOf(current.Details.UpdatedBy)
+		if current.Details.Topic != previous.Details.Topic {
+			fmt.Printf("%s set the topic to: %s\n", author, current.Details.Topic)
+		}
+		if current.Details.Description != previous.Details.Description {
+			fmt.Printf("%s changed the description: %s\n", author, current.Details.Description)
+		}
+	}
+
+	before, after := previous.Settings, current.Settings
+	if after == before {
+		return
+	}
+	fmt.Println("Channel settings:", after)
+
+	if after.MemberLimit() > before.MemberLimit() {
+		s.admitFromWaitlist()
+	}
+	if before.Waitlist && !after.Waitlist {
+		s.closeWaitlist()
+	}
+	if after.Retention != before.Retention {
+		s.thisServer.Channel.Prune(time.Now())
+		s.expireHistory(&s.thisServer.Channel)
+	}
+}
+
+// updateDetails signs new details for our current channel.
+func (s *Server) updateDetails(change func(*model.ChannelDetails)) {
+	channel := &s.thisServer.Channel
+	if channel.Creator() == "" {
+		fmt.Println("This channel has no metadata")
+		return
+	}
+	if channel.RoleOf(s.thisServer.HashID()).Rank() < role.Operator.Rank() {
+		fmt.Println("Only channel operators can change the topic and description")
+		return
+	}
+
+	m := channel.Metadata
+	change(&m.Details)
+	m.Details.UpdatedAt = time.Now()
+	if err := m.SignDetails(s.thisServer.ID.PrivateKey, s.thisServer.PublicKey); err != nil {
+		fmt.Println(err)
+		return
+	}
+	s.publishMetadata(m)
+}
+
+// updateSettings signs new settings for our current channel as its owner.
+func (s *Server) updateSettings(change func(*model.ChannelSettings)) {
+	channel := &s.thisServer.Channel
+	if channel.Creator() != s.thisServer.HashID() {
+		fmt.Println("Only the channel owner can change its settings")
+		return
+	}
+
+	m := channel.Metadata
+	change(&m.Settings)
+	m.UpdatedAt = time.Now()
+	if err := m.Sign(s.thisServer.ID.PrivateKey, s.thisServer.PublicKey); err != nil {
+		fmt.Println(err)
+		return
+	}
+	s.publishMetadata(m)
+}
+
+func (s *Server) metadataCommand(fields []string, line string) {
+	switch fields[0] {
+	case "/topic":
+		topic := argsAfter(line, 1)
+		s.updateDetails(func(d *model.ChannelDetails) { d.Topic = topic })
+	case "/describe":
+		description := argsAfter(line, 1)
+		s.updateDetails(func(d *model.ChannelDetails) { d.Description = description })
+	case "/settings":
+		fmt.Println(s.thisServer.Channel.Metadata.Settings)
+	case "/set":
+		if len(fields) != 3 {
+			fmt.Println("Usage: /set <limit|waitlist|maxlength|slowmode|attachments|retention|posting> <value>")
+			return
+		}
+		s.changeSetting(fields[1], fields[2])
+	// /limit and /waitlist are shorthands for the settings of the same name.
+	case "/limit":
+		if len(fields) != 2 {
+			fmt.Println("Usage: /limit <members>")
+			return
+		}
+		s.changeSetting("limit", fields[1])
+	case "/waitlist":
+		if len(fields) != 2 {
+			fmt.Println("Usage: /waitlist on|off")
+			return
+		}
+		s.changeSetting("waitlist", fields[1])
+	}
+}
+
+func (s *Server) changeSetting(name, value string) {
+	settings := s.thisServer.Channel.Metadata.Settings
+	if err := settings.Set(name, value); err != nil {
+		fmt.Println(err)
+		return
+	}
+	s.updateSettings(func(current *model.ChannelSettings) { *current = settings })
+}
+
+func (s *Server) listChannels() {
+	names := make([]string, 0, len(s.channels))
+	for name := range s.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		channel, members := s.channels[name], len(s.channels[name].ConnectedNodes)
+		if name == s.thisServer.Channel.ChannelName {
+			channel, members = &s.thisServer.Channel, len(s.thisServer.Channel.ConnectedNodes)+1
+		}
+
+		flags := []string{fmt.Sprintf("%d/%d", members, channel.Metadata.Settings.MemberLimit())}
+		if access := channel.Metadata.Settings.Access; access != "" {
+			flags = append(flags, string(access))
+		}
+		if channel.Metadata.Settings.Encrypted {
+			flags = append(flags, "encrypted")
+		}
+		fmt.Printf("%s (%s)\n", name, strings.Join(flags, ", "))
+		channel.Describe(s.nicknameOf)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/enum/headerType"
+	"go-p2p/enum/modAction"
+	"go-p2p/enum/role"
+	"go-p2p/model"
+	"strings"
+	"time"
+)
+
+// channelCopies returns every copy of a channel this node keeps: the entry
+// in the channel list and, while we are in it, our current channel.
+func (s *Server) channelCopies(name string) []*model.Channel {
+	var copies []*model.Channel
+	if s.thisServer.Channel.ChannelName == name {
+		copies = append(copies, &s.thisServer.Channel)
+	}
+	if channel, exists := s.channels[name]; exists {
+		copies = append(copies, channel)
+	}
+	return copies
+}
+
+func (s *Server) nicknameOf(hashId string) string {
+	if hashId == s.thisServer.HashID() {
+		return strings.TrimSpace(s.thisServer.Nickname)
+	}
+	if node, exists := s.knownNodes[hashId]; exists {
+		return strings.TrimSpace(node.Nickname)
+	}
+	if len(hashId) > 16 {
+		return hashId[:16]
+	}
+	return hashId
+}
+
+// applyModeration applies a to every copy of its channel. The first copy
+// decides whether the action is accepted.
+func (s *Server) applyModeration(a model.ModAction) error {
+	copies := s.channelCopies(a.Channel)
+	if len(copies) == 0 {
+		return fmt.Errorf("unknown channel %s", a.Channel)
+	}
+
+	if err := copies[0].Apply(a); err != nil {
+		return err
+	}
+	for _, channel := range copies[1:] {
+		channel.Apply(a)
+	}
+	return nil
+}
+
+// moderate signs an action on the current channel as this node, applies it
+// and sends it to every known node.
+func (s *Server) moderate(a model.ModAction) {
+	a.Channel = s.thisServer.Channel.ChannelName
+	a.Timestamp = time.Now()
+	if err := a.Sign(s.thisServer.ID.PrivateKey, s.thisServer.PublicKey); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if err := s.applyModeration(a); err != nil {
+		fmt.Println("Not allowed:", err)
+		return
+	}
+
+	content, err := json.Marshal(a)
+	if err != nil {
+		fmt.Println("Error encoding moderation action:", err)
+		return
+	}
+
+	msg := model.Message{Type: headerType.Moderation, Content: string(content), Nickname: s.thisServer.Nickname, Timestamp: time.Now(), HashID: s.thisServer.HashID(), Channel: a.Channel}
+	jsonData, err := s.encodeMessage(&msg)
+	if err != nil {
+		fmt.Println("Error encoding JSON:", err)
+		return
+	}
+
+	for _, node := range s.knownNodes {
+		node.Connection.Write(jsonData)
+	}
+
+	s.moderationEffects(a)
+}
+
+func (s *Server) receiveModeration(incomingMsg model.Message) {
+	var a model.ModAction
+	if err := json.Unmarshal([]byte(incomingMsg.Content), &a); err != nil {
+		fmt.Println("Error decoding moderation action:", err)
+		return
+	}
+
+	if err := s.applyModeration(a); err != nil {
+		if !errors.Is(err, model.ErrDuplicateAction) {
+			fmt.Println("Rejected moderation from", strings.TrimSpace(incomingMsg.Nickname)+":", err)
+		}
+		return
+	}
+
+	s.moderationEffects(a)
+}
+
+// moderationEffects carries out an accepted action in our current channel.
+func (s *Server) moderationEffects(a model.ModAction) {
+	if a.Channel != s.thisServer.Channel.ChannelName {
+		return
+	}
+
+	actor, target := s.nicknameOf(a.Actor), ""
+	if a.Target != "" {
+		target = s.nicknameOf(a.Target)
+	}
+
+	switch a.Action {
+	case modAction.Kick, modAction.Ban:
+		verb := "kicked"
+		if a.Action == modAction.Ban {
+			verb = "banned"
+		}
+
+		if a.Target == s.thisServer.HashID() {
+			fmt.Printf("You were %s from %s by %s.\n", verb, a.Channel, actor)
+			s.ChangeChannel(defaultChannelName, "")
+			return
+		}
+
+		fmt.Printf("%s was %s by %s.\n", target, verb, actor)
+		if _, member := s.thisServer.Channel.ConnectedNodes[a.Target]; member {
+			delete(s.thisServer.Channel.ConnectedNodes, a.Target)
+			if channel, exists := s.channels[a.Channel]; exists {
+				delete(channel.ConnectedNodes, a.Target)
+			}
+			s.memberLeft()
+		}
+	case modAction.Unban:
+		fmt.Printf("%s was unbanned by %s.\n", target, actor)
+	case modAction.Mute:
+		fmt.Printf("%s was muted by %s.\n", target, actor)
+	case modAction.Unmute:
+		fmt.Printf("%s was unmuted by %s.\n", target, actor)
+	case modAction.SetRole:
+		fmt.Printf("%s is now %s (set by %s).\n", target, a.Role, actor)
+	}
+}
+
+func (s *Server) listMembers() {
+	channel := &s.thisServer.Channel
+	if channel.Metadata.Details.Topic != "" {
+		fmt.Println("Topic:", channel.Metadata.Details.Topic)
+	}
+	channel.ListMembers()
+	fmt.Printf("%s [%s] (you)\n", strings.TrimSpace(s.thisServer.Nickname), channel.RoleOf(s.thisServer.HashID()))
+}
+
+func (s *Server) moderationCommand(fields []string) {
+	actions := map[string]modAction.Action{
+		"/kick":   modAction.Kick,
+		"/ban":    modAction.Ban,
+		"/unban":  modAction.Unban,
+		"/mute":   modAction.Mute,
+		"/unmute": modAction.Unmute,
+		"/role":   modAction.SetRole,
+	}
+
+	if (fields[0] == "/role" && len(fields) != 3) || (fields[0] != "/role" && len(fields) != 2) {
+		if fields[0] == "/role" {
+			fmt.Println("Usage: /role <nickname|id> <operator|voiced|member>")
+		} else {
+			fmt.Printf("Usage: %s <nickname|id>\n", fields[0])
+		}
+		return
+	}
+
+	target, _, err := s.resolvePeer(fields[1])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	a := model.ModAction{Action: actions[fields[0]], Target: target}
+	if fields[0] == "/role" {
+		a.Role = role.Role(fields[2])
+	}
+	s.moderate(a)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/e2e"
+	"go-p2p/enum/headerType"
+	"go-p2p/model"
+	"time"
+)
+
+// privateMessageAAD binds a private message ciphertext to its sender and
+// recipient so it cannot be replayed to or attributed to someone else.
+func privateMessageAAD(sender, recipient string) []byte {
+	return []byte(sender + ":" + recipient)
+}
+
+// loadSession returns the ratchet session with node, or nil if there is none.
+// A session set up with an encryption key the node no longer uses is
+// discarded, since the peer can no longer read it.
+func (s *Server) loadSession(node *model.Node) *e2e.Session {
+	session, err := s.sessions.Load(node.HashID())
+	if err != nil {
+		fmt.Println("Error loading private session with", node.Nickname+":", err)
+		return nil
+	}
+
+	if session != nil && !bytes.Equal(session.PeerKey, node.EncryptionKey) {
+		fmt.Println("Encryption key of", node.Nickname, "changed, starting a new private session")
+		if err := s.sessions.Delete(node.HashID()); err != nil {
+			fmt.Println("Error removing private session:", err)
+		}
+		return nil
+	}
+
+	return session
+}
+
+func (s *Server) sendPrivateMessage(message, hashId string) error {
+	msg, err := s.deliverPrivateMessage(message, hashId)
+	if err != nil {
+		return err
+	}
+
+	s.privateMessageHistory[hashId] = append(s.privateMessageHistory[hashId], msg)
+	s.storeMessage(nil, hashId, msg)
+	return nil
+}
+
+// deliverPrivateMessage encrypts message in our session with hashId and
+// sends it. Messages sent before the peer has answered the session are kept
+// until it does, since the peer drops them if its own session wins.
+func (s *Server) deliverPrivateMessage(message, hashId string) (model.Message, error) {
+	targetNode, exists := s.knownNodes[hashId]
+	if !exists {
+		return model.Message{}, errors.New("unknown node")
+	}
+
+	if err := targetNode.VerifyEncryptionKey(); err != nil {
+		return model.Message{}, fmt.Errorf("cannot encrypt to %s: %v", targetNode.Nickname, err)
+	}
+
+	session := s.loadSession(targetNode)
+	if session == nil {
+		var err error
+		if session, err = e2e.NewInitiatorSession(s.thisServer.ID.EncryptionKey, targetNode.EncryptionKey); err != nil {
+			return model.Message{}, err
+		}
+	}
+
+	sealed, err := session.Encrypt([]byte(message), privateMessageAAD(s.thisServer.HashID(), hashId))
+	if err != nil {
+		return model.Message{}, err
+	}
+
+	// The session is saved before sending so a message key is never reused,
+	// even if the node stops right after.
+	if err := s.sessions.Save(hashId, session); err != nil {
+		return model.Message{}, err
+	}
+
+	content, err := json.Marshal(sealed)
+	if err != nil {
+		return model.Message{}, err
+	}
+
+	ts := time.Now()
+
+	msg := model.Message{Content: string(content), Nickname: s.thisServer.Nickname, Timestamp: ts, Type: headerType.PrivateMessage, HashID: s.thisServer.HashID(), Recipient: hashId}
+
+	jsonData, err := s.encodeMessage(&msg)
+	if err != nil {
+		return model.Message{}, err
+	}
+
+	// The answer can arrive before Write returns, so the message is kept
+	// before it goes out.
+	unconfirmed := session.Initiator && !session.Acknowledged
+	if unconfirmed {
+		s.unconfirmed[hashId] = append(s.unconfirmed[hashId], message)
+	}
+
+	if _, err := targetNode.Connection.Write(jsonData); err != nil {
+		if unconfirmed {
+			s.unconfirmed[hashId] = s.unconfirmed[hashId][:len(s.unconfirmed[hashId])-1]
+		}
+		return model.Message{}, err
+	}
+
+	msg.Content = message
+	return msg, nil
+}
+
+func (s *Server) receivePrivateMessage(incomingMsg model.Message) {
+	if incomingMsg.Recipient != s.thisServer.HashID() {
+		fmt.Println("Dropping private message addressed to", incomingMsg.Recipient)
+		return
+	}
+
+	if s.blocks.ignores(incomingMsg.HashID) {
+		return
+	}
+
+	var sealed e2e.RatchetMessage
+	if err := json.Unmarshal([]byte(incomingMsg.Content), &sealed); err != nil || len(sealed.Header.DH) == 0 {
+		fmt.Println("Rejected unencrypted private message from", incomingMsg.Nickname)
+		return
+	}
+
+	sender, exists := s.knownNodes[incomingMsg.HashID]
+	if !exists {
+		fmt.Println("Rejected private message from unknown node", incomingMsg.Nickname)
+		return
+	}
+
+	if err := sender.VerifyEncryptionKey(); err != nil {
+		fmt.Println("Rejected private message from", incomingMsg.Nickname+":", err)
+		return
+	}
+
+	session := s.loadSession(sender)
+	abandoned := false
+	if sealed.Header.Init != nil && (session == nil || !bytes.Equal(session.Init, sealed.Header.Init)) {
+		// When both sides start a session at the same time, the one started
+		// by the lower ID wins and the other is abandoned.
+		if session != nil && session.Initiator && !session.Acknowledged && s.thisServer.HashID() < sender.HashID() {
+			fmt.Println("Ignoring concurrent private session from", incomingMsg.Nickname+"; it resends under ours")
+			return
+		}
+		abandoned = session != nil && session.Initiator && !session.Acknowledged
+
+		if session != nil {
+			fmt.Println(incomingMsg.Nickname, "started a new private session")
+		}
+
+		var err error
+		if session, err = e2e.NewResponderSession(s.thisServer.ID.EncryptionKey, sender.EncryptionKey, sealed.Header.Init); err != nil {
+			fmt.Println("Rejected private message from", incomingMsg.Nickname+":", err)
+			return
+		}
+	}
+
+	if session == nil {
+		fmt.Println("Rejected private message from", incomingMsg.Nickname+": no private session")
+		return
+	}
+
+	plaintext, err := session.Decrypt(sealed, privateMessageAAD(incomingMsg.HashID, incomingMsg.Recipient))
+	if err != nil {
+		fmt.Println("Rejected private message from", incomingMsg.Nickname+":", err)
+		return
+	}
+
+	if err := s.sessions.Save(incomingMsg.HashID, session); err != nil {
+		fmt.Println("Error saving private session:", err)
+	}
+
+	incomingMsg.Content = string(plaintext)
+	s.privateMessageHistory[incomingMsg.HashID] = append(s.privateMessageHistory[incomingMsg.HashID], incomingMsg)
+	s.storeMessage(nil, incomingMsg.HashID, incomingMsg)
+	fmt.Println("[PM]", incomingMsg.PrintMessage())
+
+	// The peer has now answered a session with us. If that is not the one
+	// our earlier messages went out in, it never read them.
+	pending := s.unconfirmed[incomingMsg.HashID]
+	delete(s.unconfirmed, incomingMsg.HashID)
+	if abandoned && len(pending) > 0 {
+		fmt.Printf("Resending %d private messages to %s in the new session\n", len(pending), incomingMsg.Nickname)
+		for _, text := range pending {
+			if _, err := s.deliverPrivateMessage(text, incomingMsg.HashID); err != nil {
+				fmt.Println("Error resending private message:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Messages stamped further than this from our clock are refused, which also
+// bounds how long a nonce has to be remembered.
+const messageWindow = 5 * time.Minute
+
+func newNonce() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	return hex.EncodeToString(nonce)
+}
+
+// replayCache remembers the nonces each sender used within the timestamp
+// window, so a captured message cannot be delivered twice.
+type replayCache struct {
+	mu   sync.Mutex
+	seen map[string]map[string]time.Time
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{seen: make(map[string]map[string]time.Time)}
+}
+
+func (rc *replayCache) check(sender, nonce string, timestamp time.Time) error {
+	if nonce == "" {
+		return errors.New("message has no nonce")
+	}
+
+	now := time.Now()
+	if age := now.Sub(timestamp); age > messageWindow || age < -messageWindow {
+		return errors.New("message timestamp outside accepted window")
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	nonces, exists := rc.seen[sender]
+	if !exists {
+		nonces = make(map[string]time.Time)
+		rc.seen[sender] = nonces
+	}
+
+	if _, replayed := nonces[nonce]; replayed {
+		return errors.New("replayed message")
+	}
+
+	// A nonce can be forgotten once its message's timestamp has left the
+	// window, since a replay would be refused on that alone.
+	for seen, stamped := range nonces {
+		if now.Sub(stamped) > messageWindow {
+			delete(nonces, seen)
+		}
+	}
+
+	nonces[nonce] = timestamp
+	return nil
+}
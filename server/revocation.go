@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-p2p/enum/headerType"
+	"go-p2p/model"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// forgetNode drops a peer from every channel and from the known nodes and
+// closes our connection to it.
+func (s *Server) forgetNode(hashId string) {
+	node, exists := s.knownNodes[hashId]
+	if !exists {
+		return
+	}
+
+	if node.Connection != nil {
+		node.Connection.Close()
+	}
+
+	for _, channel := range s.channels {
+		delete(channel.ConnectedNodes, hashId)
+	}
+	if _, member := s.thisServer.Channel.ConnectedNodes[hashId]; member {
+		delete(s.thisServer.Channel.ConnectedNodes, hashId)
+		s.memberLeft()
+	}
+	delete(s.knownNodes, hashId)
+}
+
+func (s *Server) receiveRevocation(incomingMsg model.Message) {
+	var rev model.Revocation
+	if err := json.Unmarshal([]byte(incomingMsg.Content), &rev); err != nil {
+		fmt.Println("Error decoding revocation:", err)
+		return
+	}
+
+	s.applyRevocation(rev, incomingMsg.HashID)
+}
+
+// applyRevocation stores a revocation and, the first time it is seen, stops
+// trusting the identity and passes the revocation on to every other peer and
+// the mirrors.
+func (s *Server) applyRevocation(rev model.Revocation, from string) {
+	added, err := s.revocations.Add(rev)
+	if err != nil && !added {
+		fmt.Println("Rejected revocation:", err)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+	if !added {
+		return
+	}
+
+	identity := rev.Identity()
+	name := identity[:16]
+	if node, exists := s.knownNodes[identity]; exists {
+		name = strings.TrimSpace(node.Nickname)
+	}
+	fmt.Printf("WARNING: the identity of %s has been revoked (%s)\n", name, rev.Reason)
+
+	// The revoked node is told as well, so a node that is still ours stops.
+	s.broadcastRevocation(rev, from)
+	s.postRevocation(rev)
+	s.forgetNode(identity)
+
+	if identity == s.thisServer.HashID() {
+		fmt.Println("This node's identity has been revoked. Create a new profile to rejoin the network.")
+		os.Exit(1)
+	}
+}
+
+func (s *Server) broadcastRevocation(rev model.Revocation, exclude string) {
+	content, err := json.Marshal(rev)
+	if err != nil {
+		fmt.Println("Error encoding revocation:", err)
+		return
+	}
+
+	msg := model.Message{Type: headerType.Revocation, Content: string(content), Nickname: s.thisServer.Nickname, Timestamp: time.Now(), HashID: s.thisServer.HashID()}
+	jsonData, err := s.encodeMessage(&msg)
+	if err != nil {
+		fmt.Println("Error encoding JSON:", err)
+		return
+	}
+
+	for hashId, node := range s.knownNodes {
+		if hashId != exclude && node.Connection != nil {
+			node.Connection.Write(jsonData)
+		}
+	}
+}
+
+func (s *Server) postRevocation(rev model.Revocation) {
+	revJSON, err := json.Marshal(rev)
+	if err != nil {
+		fmt.Println("Error encoding revocation:", err)
+		return
+	}
+
+	for _, mirror := range s.knownMirrors {
+		url := fmt.Sprintf("http://%s:%s/revoke", mirror.Hostname, mirror.Port)
+		resp, err := http.Post(url, "application/json", bytes.NewBuffer(revJSON))
+		if err != nil {
+			fmt.Println("Error sending revocation to", mirror.Nickname, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			fmt.Println("Mirror", mirror.Nickname, "refused revocation:", resp.Status)
+		}
+	}
+}
+
+// revokeSelf revokes this node's own identity everywhere and stops the node.
+func (s *Server) revokeSelf(reason string) {
+	rev, err := model.NewRevocation(s.thisServer.ID.PrivateKey, s.thisServer.PublicKey, reason)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	s.applyRevocation(rev, "")
+}
+
+// writeRevocationCert stores a revocation of this node's identity without
+// publishing it, to be kept somewhere safe and published if the key is lost.
+func (s *Server) writeRevocationCert(path, reason string) {
+	rev, err := model.NewRevocation(s.thisServer.ID.PrivateKey, s.thisServer.PublicKey, reason)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	data, err := json.MarshalIndent(rev, "", "  ")
+	if err != nil {
+		fmt.Println("Error encoding revocation:", err)
+		return
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		fmt.Println("Error writing revocation certificate:", err)
+		return
+	}
+	fmt.Println("Revocation certificate written to", path)
+}
+
+func (s *Server) publishRevocationCert(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println("Error reading revocation certificate:", err)
+		return
+	}
+
+	var rev model.Revocation
+	if err := json.Unmarshal(data, &rev); err != nil {
+		fmt.Println("Error decoding revocation certificate:", err)
+		return
+	}
+
+	s.applyRevocation(rev, "")
+}
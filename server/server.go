@@ -4,14 +4,19 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
+	"flag"
 	"fmt"
 	"go-p2p/certs"
+	"go-p2p/e2e"
+	"go-p2p/enum/accessMode"
 	"go-p2p/enum/headerType"
+	"go-p2p/enum/keyAlgorithm"
+	"go-p2p/history"
 	"go-p2p/model"
+	"go-p2p/profile"
+	"go-p2p/utils"
 	"io"
 	"net"
 	"net/http"
@@ -27,6 +32,21 @@ type Server struct {
 	knownMirrors          []model.Node
 	channels              map[string]*model.Channel
 	privateMessageHistory map[string][]model.Message
+	// unconfirmed holds private messages sent in a session the peer has not
+	// answered yet, in case it drops that session for its own.
+	unconfirmed map[string][]string
+	// refused holds nodes turned away over a changed certificate, address or
+	// nickname, until the user accepts the change.
+	refused     map[string]model.Node
+	profile     *profile.Profile
+	sessions    *e2e.SessionStore
+	history     *history.Store
+	channelKeys map[string]*channelKeyring
+	verified    *verifiedContacts
+	blocks      *blockList
+	limiter     *rateLimiter
+	replays     *replayCache
+	revocations *model.RevocationList
 }
 
 var defaultChannelName = "lobby"
@@ -36,54 +56,157 @@ var defaultChans = map[string]*model.Channel{
 }
 
 func (s *Server) connectToNode(node model.Node) net.Conn {
+	if s.blocks.refuses(node.HashID()) || s.limiter.banned(node.HashID()) || s.revocations.Revoked(node.HashID()) {
+		return nil
+	}
+	if s.checkBindings(node) != nil {
+		return nil
+	}
+
 	fmt.Println("Connecting to node", node.Address())
 
-	conn, err := tls.Dial("tcp", node.Address(), s.thisServer.ID.Config)
+	conn, err := tls.Dial("tcp", node.Address(), s.thisServer.ID.DialConfig(node.Address(), node.PublicKey))
 	if err != nil {
+		// A refused certificate change waits for /accept-peer.
+		var changed *certs.PeerChangedError
+		if errors.As(err, &changed) {
+			s.refused[node.HashID()] = node
+		}
 		fmt.Println("Error connecting to node:", err)
-		os.Exit(1)
+		return nil
 	}
 
 	node.Connection = conn
+	node.Channel = model.NewChannel(defaultChannelName)
 	s.knownNodes[node.HashID()] = &node
+	s.checkVerified(&node)
+
+	// Nodes start out in the lobby until they tell us otherwise.
+	s.channels[defaultChannelName].ConnectedNodes[node.HashID()] = node
+	if s.thisServer.Channel.ChannelName == defaultChannelName {
+		s.thisServer.Channel.ConnectedNodes[node.HashID()] = node
+	}
 	return conn
 }
 
-func (s *Server) addNode(host, port, nickname string) {
-	fmt.Println("node: {} {} {}", host, port, nickname)
-	node := model.Node{Hostname: host, Port: port, Nickname: nickname, Channel: defaultChannel}
+// addNode records a peer by identity. A known identity arriving from a new
+// address or under a new nickname is the same peer, so only its attributes
+// are updated and its connection is re-established if it moved.
+func (s *Server) addNode(node model.Node) {
+	if existing, exists := s.knownNodes[node.HashID()]; exists {
+		if existing.Address() != node.Address() {
+			fmt.Printf("%s moved to %s\n", strings.TrimSpace(existing.Nickname), node.Address())
+			existing.Connection.Close()
+			existing.Hostname, existing.Port = node.Hostname, node.Port
+			conn, err := tls.Dial("tcp", node.Address(), s.thisServer.ID.DialConfig(node.Address(), node.PublicKey))
+			if err != nil {
+				// Its old connection is closed, so it is dropped until it
+				// announces itself again.
+				fmt.Println("Error connecting to node:", err)
+				s.removeNode(node.HashID())
+				return
+			}
+			existing.Connection = conn
+		}
+		if strings.TrimSpace(existing.Nickname) != strings.TrimSpace(node.Nickname) {
+			fmt.Printf("%s is now known as %s\n", strings.TrimSpace(existing.Nickname), strings.TrimSpace(node.Nickname))
+			existing.Nickname = node.Nickname
+		}
+		if !bytes.Equal(existing.EncryptionKey, node.EncryptionKey) && node.VerifyEncryptionKey() == nil {
+			existing.EncryptionKey, existing.EncryptionKeySig = node.EncryptionKey, node.EncryptionKeySig
+		}
+		s.checkVerified(existing)
+		s.refreshMember(existing)
+		return
+	}
 
-	if _, exists := s.knownNodes[node.HashID()]; exists {
+	if s.connectToNode(node) == nil {
 		return
 	}
 
-	s.connectToNode(node)
+	// A node that just arrived assumes everyone is in the lobby.
+	if s.thisServer.Channel.ChannelName != defaultChannelName {
+		s.announceChannel(s.knownNodes[node.HashID()])
+	}
 }
 
-func (s *Server) handleChannel(channelList []model.Channel) {
-	for _, chanName := range channelList {
-		if _, exists := s.channels[chanName.ChannelName]; exists {
-			continue
-		} else {
-			s.channels[chanName.ChannelName] = &chanName
+// refreshMember replaces the copies of node held in channel member lists,
+// which would otherwise keep its old nickname and connection.
+func (s *Server) refreshMember(node *model.Node) {
+	hashId := node.HashID()
+	if _, member := s.thisServer.Channel.ConnectedNodes[hashId]; member {
+		s.thisServer.Channel.ConnectedNodes[hashId] = *node
+	}
+	for _, channel := range s.channels {
+		if _, member := channel.ConnectedNodes[hashId]; member {
+			channel.ConnectedNodes[hashId] = *node
+		}
+	}
+}
+
+// verifyHandshake checks that a NewNode message carries the key its HashID
+// claims and that it is the key the connection authenticated with.
+func verifyHandshake(incomingMsg model.Message, conn net.Conn) error {
+	if len(incomingMsg.PublicKey) == 0 || model.KeyFingerprint(incomingMsg.PublicKey) != incomingMsg.HashID {
+		return errors.New("public key does not match claimed identity")
+	}
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok || len(tlsConn.ConnectionState().PeerCertificates) == 0 {
+		return errors.New("connection is not authenticated")
+	}
+
+	if !bytes.Equal(tlsConn.ConnectionState().PeerCertificates[0].RawSubjectPublicKeyInfo, incomingMsg.PublicKey) {
+		return errors.New("public key differs from connection certificate")
+	}
+	return nil
+}
+
+// handleChannel records the channels a node knows of. A channel's creator
+// and moderation log are only taken from the creator's own list.
+func (s *Server) handleChannel(channelList map[string]model.Channel, hashId string) {
+	for _, listed := range channelList {
+		channel, exists := s.channels[listed.ChannelName]
+		if !exists {
+			created := model.NewChannel(listed.ChannelName)
+			if listed.ConnectedNodes != nil {
+				created.ConnectedNodes = listed.ConnectedNodes
+			}
+			channel = &created
+			s.channels[listed.ChannelName] = channel
+		}
+
+		if channel.Creator() == "" && listed.Creator() == hashId && s.foundChannel(listed.Metadata, hashId) == nil {
+			channel.Adopt(listed)
 		}
 	}
 }
 
-func (s *Server) handleMessage(incomingMsg model.Message) {
+func (s *Server) handleMessage(incomingMsg model.Message, conn net.Conn) {
 	header := incomingMsg.Type
 
 	switch header {
 	// New Node
 	case headerType.NewNode:
-		var incomingChannel []model.Channel
+		var incomingChannel map[string]model.Channel
 		if err := json.Unmarshal([]byte(incomingMsg.Content), &incomingChannel); err != nil {
 			fmt.Println("Error unmarshaling Content into Channel:", err)
 			return
 		}
 
-		s.handleChannel(incomingChannel)
-		s.addNode(incomingMsg.Hostname, incomingMsg.Port, incomingMsg.Nickname)
+		if err := verifyHandshake(incomingMsg, conn); err != nil {
+			fmt.Println("Rejected node", strings.TrimSpace(incomingMsg.Nickname)+":", err)
+			return
+		}
+
+		node := model.Node{Hostname: incomingMsg.Hostname, Port: incomingMsg.Port, Nickname: incomingMsg.Nickname, PublicKey: incomingMsg.PublicKey, EncryptionKey: incomingMsg.EncryptionKey, EncryptionKeySig: incomingMsg.EncryptionKeySig}
+		if s.checkBindings(node) != nil {
+			conn.Close()
+			return
+		}
+
+		s.handleChannel(incomingChannel, incomingMsg.HashID)
+		s.addNode(node)
 	// New Channel
 	case headerType.NewChannel:
 		s.updateNewChannel(incomingMsg.Content, incomingMsg.Nickname, incomingMsg.HashID)
@@ -92,17 +215,64 @@ func (s *Server) handleMessage(incomingMsg model.Message) {
 		s.updateChannelList(incomingMsg.Content, incomingMsg.Nickname, incomingMsg.HashID)
 	// Channel Info
 	case headerType.ChannelInfo:
-		s.joinChannel(incomingMsg.Content)
+		s.joinChannel(incomingMsg.Content, incomingMsg.HashID)
+	// Join Rejected
+	case headerType.JoinRejected:
+		s.receiveJoinRejection(incomingMsg)
+	// Join Admitted
+	case headerType.JoinAdmitted:
+		s.receiveJoinAdmission(incomingMsg)
+	// Channel Metadata
+	case headerType.Metadata:
+		s.receiveMetadata(incomingMsg)
+	// History
+	case headerType.HistoryRequest:
+		s.receiveHistoryRequest(incomingMsg)
+	case headerType.HistoryPage:
+		s.receiveHistoryPage(incomingMsg)
 	// Private Message
 	case headerType.PrivateMessage:
-		s.privateMessageHistory[incomingMsg.Nickname] = append(s.privateMessageHistory[incomingMsg.HashID], incomingMsg)
+		s.receivePrivateMessage(incomingMsg)
 	// Exit Message
 	case headerType.Exit:
 		s.removeNode(incomingMsg.HashID)
 	// Chat Message
 	case headerType.ChatMessage:
-		s.thisServer.Channel.ChatHistory = append(s.thisServer.Channel.ChatHistory, incomingMsg)
+		if s.blocks.ignores(incomingMsg.HashID) || s.thisServer.Channel.MayPost(incomingMsg.HashID) != nil {
+			return
+		}
+		// A kicked node keeps its connection and any node can address a
+		// password or invite-only channel, so chat is only taken from the
+		// nodes admitted to our channel.
+		if incomingMsg.HashID != s.thisServer.HashID() && !isMember(&s.thisServer.Channel, incomingMsg.HashID) {
+			return
+		}
+		if err := s.openChannelMessage(&incomingMsg); err != nil {
+			fmt.Println("Could not read message from", strings.TrimSpace(incomingMsg.Nickname)+":", err)
+			return
+		}
+		// Every node enforces the channel settings, so a message that
+		// breaks them is dropped even if its sender's node let it through.
+		if err := s.thisServer.Channel.CheckMessage(incomingMsg); err != nil {
+			return
+		}
+		if !s.thisServer.Channel.Record(incomingMsg) {
+			return
+		}
+		s.storeMessage(&s.thisServer.Channel, "", incomingMsg)
 		fmt.Print(incomingMsg.PrintMessage())
+	// Channel Key
+	case headerType.ChannelKey:
+		s.receiveChannelKey(incomingMsg)
+	// Certificate Update
+	case headerType.CertUpdate:
+		s.updatePeerCertificate(incomingMsg, conn)
+	// Revocation
+	case headerType.Revocation:
+		s.receiveRevocation(incomingMsg)
+	// Moderation
+	case headerType.Moderation:
+		s.receiveModeration(incomingMsg)
 	default:
 		fmt.Println("Invalid message: {}", string(incomingMsg.ToJson()))
 	}
@@ -110,7 +280,40 @@ func (s *Server) handleMessage(incomingMsg model.Message) {
 
 func (s *Server) connectionServer(conn net.Conn) {
 	defer conn.Close()
-	decoder := json.NewDecoder(conn)
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+
+	if err := tlsConn.Handshake(); err != nil {
+		fmt.Println("TLS handshake failed:", conn.RemoteAddr().String(), err)
+		return
+	}
+
+	// Every message on this connection is checked against the identity the
+	// peer proved with its certificate.
+	peerKey := tlsConn.ConnectionState().PeerCertificates[0].RawSubjectPublicKeyInfo
+	peerID := model.KeyFingerprint(peerKey)
+
+	if s.blocks.refuses(peerID) {
+		fmt.Println("Refused connection from blocked peer", conn.RemoteAddr().String())
+		return
+	}
+
+	if s.limiter.banned(peerID) {
+		fmt.Println("Refused connection from banned peer", conn.RemoteAddr().String())
+		return
+	}
+
+	if s.revocations.Revoked(peerID) {
+		fmt.Println("Refused connection from revoked identity", conn.RemoteAddr().String())
+		return
+	}
+
+	maxSize := s.limiter.limits.MaxMessageBytes
+	reader := &frameReader{r: conn, limit: int64(maxSize)}
+	decoder := json.NewDecoder(reader)
 
 	for {
 		var incomingMsg model.Message
@@ -119,13 +322,38 @@ func (s *Server) connectionServer(conn net.Conn) {
 			if err == io.EOF {
 				fmt.Println("Connection closed by client:", conn.RemoteAddr().String())
 				break
+			} else if errors.Is(err, net.ErrClosed) {
+				// We closed it ourselves, e.g. after refusing the node.
+				return
+			} else if errors.Is(err, errFrameTooLarge) {
+				s.penalize(peerID, conn.RemoteAddr().String(), fmt.Sprintf("message larger than %d bytes", maxSize))
+				return
 			} else {
 				fmt.Println("Error reading message:", err)
 				return
 			}
 		}
+		reader.nextFrame(decoder, maxSize)
+
+		// Our own connections echo what we type and are not limited.
+		if peerID != s.thisServer.HashID() && !s.limiter.allow(peerID, incomingMsg.Type) {
+			s.penalize(peerID, conn.RemoteAddr().String(), fmt.Sprintf("too many %s messages", incomingMsg.Type))
+			return
+		}
+
+		// The peer may have been blocked while connected.
+		if s.blocks.refuses(peerID) {
+			return
+		}
 
-		s.handleMessage(incomingMsg)
+		if err := s.authenticate(incomingMsg, peerID, peerKey); err != nil {
+			fmt.Println("Rejected message from", conn.RemoteAddr().String()+":", err)
+			continue
+		}
+		// Plaintext is unsigned, so only this node's own decryption fills it.
+		incomingMsg.Plaintext = ""
+
+		s.handleMessage(incomingMsg, conn)
 	}
 }
 
@@ -135,6 +363,9 @@ func (s *Server) networkBroadcast(nodeList []model.Node) {
 			continue
 		}
 		conn := s.connectToNode(node)
+		if conn == nil {
+			continue
+		}
 		node.Connection = conn
 		fmt.Println("Connected to", conn.RemoteAddr().String())
 
@@ -145,9 +376,9 @@ func (s *Server) networkBroadcast(nodeList []model.Node) {
 			continue
 		}
 
-		nodeInfo := model.Message{Type: headerType.NewNode, Hostname: s.thisServer.Hostname, Port: s.thisServer.Port, Nickname: s.thisServer.Nickname, Timestamp: time.Now(), Content: string(channelListJSON), HashID: s.thisServer.HashID()}
+		nodeInfo := model.Message{Type: headerType.NewNode, Hostname: s.thisServer.Hostname, Port: s.thisServer.Port, Nickname: s.thisServer.Nickname, Timestamp: time.Now(), Content: string(channelListJSON), HashID: s.thisServer.HashID(), PublicKey: s.thisServer.PublicKey, EncryptionKey: s.thisServer.EncryptionKey, EncryptionKeySig: s.thisServer.EncryptionKeySig}
 
-		jsonData, err := json.Marshal(nodeInfo)
+		jsonData, err := s.encodeMessage(&nodeInfo)
 		if err != nil {
 			fmt.Println("Error encoding JSON:", err)
 			continue
@@ -162,24 +393,54 @@ func (s *Server) networkBroadcast(nodeList []model.Node) {
 	}
 }
 
+func (s *Server) signedRegistration() ([]byte, error) {
+	reg := model.Registration{Node: model.Node{Hostname: s.thisServer.Hostname, Port: s.thisServer.Port, Nickname: s.thisServer.Nickname, PublicKey: s.thisServer.PublicKey, EncryptionKey: s.thisServer.EncryptionKey, EncryptionKeySig: s.thisServer.EncryptionKeySig}, Timestamp: time.Now()}
+	if err := reg.Sign(s.thisServer.ID.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(reg)
+}
+
 func (s *Server) connectToMirror() {
 	for _, mirror := range s.knownMirrors {
 		fmt.Println("Connecting to ", mirror.Nickname)
 
+		regJSON, err := s.signedRegistration()
+		if err != nil {
+			fmt.Println("Error creating registration:", err)
+			return
+		}
+
 		url := fmt.Sprintf("http://%s:%s/getNodes", mirror.Hostname, mirror.Port)
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(s.thisServer.ToJson()))
+		resp, err := http.Post(url, "application/json", bytes.NewBuffer(regJSON))
 		if err != nil {
 			fmt.Println("Error sending request:", err)
 			return
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			fmt.Println("Mirror refused registration:", strings.TrimSpace(string(body)))
+			return
+		}
+
 		var msg model.DiscoverMessage
 		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
 			fmt.Println("Error decoding JSON:", err)
 			return
 		}
 
+		for _, rev := range msg.Revocations {
+			s.applyRevocation(rev, "")
+		}
+
+		if msg.Nickname != "" && msg.Nickname != strings.TrimSpace(s.thisServer.Nickname) {
+			fmt.Println("Nickname is registered to another key, using", msg.Nickname)
+			s.thisServer.Nickname = msg.Nickname
+		}
+
 		s.networkBroadcast(msg.NodeList)
 
 		fmt.Println("Response from server:", msg)
@@ -197,12 +458,43 @@ func (s *Server) connectToMirror() {
 	}
 }
 
+func (s *Server) renewNicknames() {
+	for _, mirror := range s.knownMirrors {
+		regJSON, err := s.signedRegistration()
+		if err != nil {
+			fmt.Println("Error creating registration:", err)
+			return
+		}
+
+		url := fmt.Sprintf("http://%s:%s/renew", mirror.Hostname, mirror.Port)
+		resp, err := http.Post(url, "application/json", bytes.NewBuffer(regJSON))
+		if err != nil {
+			fmt.Println("Error renewing nickname with", mirror.Nickname, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			fmt.Println("Mirror", mirror.Nickname, "refused nickname renewal:", resp.Status)
+		}
+	}
+}
+
+func (s *Server) startRenewal() {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.renewNicknames()
+	}
+}
+
 func (s *Server) exit() {
 	ts := time.Now()
 
 	msg := model.Message{Type: headerType.Exit, Hostname: s.thisServer.Hostname, Port: s.thisServer.Port, Timestamp: ts, HashID: s.thisServer.HashID()}
 
-	jsonData, err := json.Marshal(msg)
+	jsonData, err := s.encodeMessage(&msg)
 	if err != nil {
 		fmt.Println("Error encoding JSON:", err)
 		return
@@ -216,30 +508,22 @@ func (s *Server) exit() {
 	os.Exit(0)
 }
 
-func (s *Server) sendPrivateMessage(message, address string) {
-	targetNode := s.knownNodes[address]
-
-	ts := time.Now()
-
-	msg := model.Message{Content: message, Nickname: s.thisServer.Nickname, Timestamp: ts, Type: headerType.PrivateMessage, HashID: s.thisServer.HashID()}
-
-	jsonData, err := json.Marshal(msg)
-	if err != nil {
-		fmt.Println("Error encoding JSON:", err)
+// Channel Functions
+func (s *Server) updateNewChannel(content, nickname, hashId string) {
+	var incomingChannel model.Channel
+	if err := json.Unmarshal([]byte(content), &incomingChannel); err != nil {
+		fmt.Println("Error unmarshaling Content into Channel:", err)
 		return
 	}
+	channel := incomingChannel.ChannelName
 
-	targetNode.Connection.Write(jsonData)
-}
-
-// Channel Functions
-func (s *Server) updateNewChannel(channel, nickname, hashId string) {
 	if node, exists := s.knownNodes[hashId]; exists {
 		node.Channel = model.NewChannel(channel)
 
 		if _, exists := s.thisServer.Channel.ConnectedNodes[hashId]; exists {
-			delete(s.thisServer.Channel.ConnectedNodes, node.Address())
+			delete(s.thisServer.Channel.ConnectedNodes, hashId)
 			fmt.Printf("%s has left the channel.\n", nickname)
+			s.memberLeft()
 		}
 	}
 
@@ -247,61 +531,132 @@ func (s *Server) updateNewChannel(channel, nickname, hashId string) {
 		newChannel := model.NewChannel(channel)
 		s.channels[channel] = &newChannel
 	}
+	if err := s.foundChannel(incomingChannel.Metadata, hashId); err != nil {
+		fmt.Println("Ignoring metadata of channel", channel+":", err)
+	}
 }
 
-func (s *Server) updateChannelList(channel, nickname, hashId string) {
+// updateChannelList records that a node moved to another channel. When it
+// joins ours, the join request is checked before the node is admitted or
+// sees any history.
+func (s *Server) updateChannelList(content, nickname, hashId string) {
+	req, err := parseJoinRequest(content)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	channel := req.Channel
+
 	if node, exists := s.knownNodes[hashId]; exists {
+		var refused error
+		if channel == s.thisServer.Channel.ChannelName {
+			if !s.admitsJoins() {
+				refused = errNotAdmitted
+			} else if refused = s.thisServer.Channel.CheckJoin(req, hashId); refused == nil && channel != defaultChannelName && s.thisServer.Channel.Full() {
+				refused = errChannelFull
+			}
+
+			// Other members keep their own copy of the waitlist, in case
+			// they become the coordinator, and otherwise wait for its word.
+			if refused != errNotAdmitted && !s.decidesJoins() {
+				if refused == errChannelFull && s.thisServer.Channel.Metadata.Settings.Waitlist {
+					s.thisServer.Channel.Enqueue(hashId)
+				}
+				refused = errDeferred
+			}
+		} else {
+			s.thisServer.Channel.Dequeue(hashId)
+		}
+
 		if existingChan, exists := s.channels[node.Channel.ChannelName]; exists {
 			if _, exists := existingChan.ConnectedNodes[hashId]; exists {
-				delete(existingChan.ConnectedNodes, node.Address())
+				delete(existingChan.ConnectedNodes, hashId)
 			}
 		} else {
 			fmt.Println("Channel not found:", node.Channel.ChannelName)
 		}
 
 		if newChannel, exists := s.channels[channel]; exists {
-			newChannel.ConnectedNodes[node.Address()] = *node
+			if refused == nil {
+				newChannel.ConnectedNodes[hashId] = *node
+			}
 		} else {
 			fmt.Println("Channel not found:", channel)
 		}
+		node.Channel = model.NewChannel(channel)
 
-		if channel == s.thisServer.Channel.ChannelName {
-			s.thisServer.Channel.ConnectedNodes[hashId] = *node
-			fmt.Printf("%s has joined the channel.\n", nickname)
-
-			channelInfo, _ := json.Marshal(s.thisServer.Channel)
-			msg := model.Message{Type: headerType.ChannelInfo, Hostname: s.thisServer.Hostname, Port: s.thisServer.Port, Content: string(channelInfo), Nickname: s.thisServer.Nickname, Timestamp: time.Now(), HashID: s.thisServer.HashID()}
-			jsonData, _ := json.Marshal(msg)
-			node.Connection.Write(jsonData)
+		if refused != nil {
+			if refused != errNotAdmitted && refused != errDeferred {
+				s.rejectJoin(node, refused)
+			}
+		} else if channel == s.thisServer.Channel.ChannelName {
+			s.admitMember(node)
+			s.announceAdmission(node)
 		} else if _, exists := s.thisServer.Channel.ConnectedNodes[hashId]; exists {
-			delete(s.thisServer.Channel.ConnectedNodes, node.Address())
+			delete(s.thisServer.Channel.ConnectedNodes, hashId)
 			fmt.Printf("%s has left the channel.\n", nickname)
+			s.memberLeft()
 		}
 	}
 }
 
-func (s *Server) joinChannel(channel string) {
+// joinChannel merges the channel info a member sends after we joined its
+// channel. Members are taken from our known nodes, which hold live
+// connections, rather than from the copies in the message. History follows
+// separately, in pages we ask the member for.
+func (s *Server) joinChannel(channel, hashId string) {
 	var incomingChannel model.Channel
 	if err := json.Unmarshal([]byte(channel), &incomingChannel); err != nil {
 		fmt.Println("Error unmarshaling Content into Channel:", err)
 		return
 	}
 
-	if channel != s.thisServer.Channel.ChannelName {
-		s.thisServer.Channel = incomingChannel
-		s.thisServer.Channel.OrderMessages()
-		s.thisServer.Channel.PrintHistory()
-		fmt.Printf("%s has joined the channel.\n", s.thisServer.Nickname)
+	if incomingChannel.ChannelName != s.thisServer.Channel.ChannelName {
+		return
+	}
+	// Only a member or the creator can admit us and tell us who is in.
+	if known, exists := s.channels[incomingChannel.ChannelName]; !exists || (known.Creator() != hashId && !isMember(known, hashId)) {
+		return
+	}
+	if s.thisServer.Channel.Waiting {
+		s.thisServer.Channel.Waiting = false
+		fmt.Printf("A place opened up in %s.\n", incomingChannel.ChannelName)
+	}
+
+	s.thisServer.Channel.Adopt(incomingChannel)
+	if !s.thisServer.Channel.Admitted {
+		s.thisServer.Channel.Admitted = true
+		s.thisServer.Channel.Describe(s.nicknameOf)
 	}
+
+	for memberId := range incomingChannel.ConnectedNodes {
+		if node, exists := s.knownNodes[memberId]; exists {
+			s.thisServer.Channel.ConnectedNodes[memberId] = *node
+		}
+	}
+	if node, exists := s.knownNodes[hashId]; exists {
+		s.thisServer.Channel.ConnectedNodes[hashId] = *node
+	}
+
+	s.syncHistory(hashId)
 }
 
 func (s *Server) sendMessageToChannel() {
 	reader := bufio.NewReader(os.Stdin)
 	for {
 		fmt.Print("Enter message: ")
-		text, _ := reader.ReadString('\n')
+		text, err := reader.ReadString('\n')
+		if err != nil {
+			fmt.Println()
+			return
+		}
 		ts := time.Now()
 
+		if strings.HasPrefix(text, "/") {
+			s.handleCommand(strings.TrimSpace(text))
+			continue
+		}
+
 		msg := model.Message{Type: headerType.ChatMessage, Content: text, Nickname: s.thisServer.Nickname, Timestamp: ts, HashID: s.thisServer.HashID()}
 
 		if text == "EXIT\n" {
@@ -309,43 +664,76 @@ func (s *Server) sendMessageToChannel() {
 			return
 		}
 
-		jsonData, err := json.Marshal(msg)
-		if err != nil {
-			fmt.Println("Error encoding JSON:", err)
-			continue
-		}
-
-		for _, node := range s.thisServer.Channel.ConnectedNodes {
-			node.Connection.Write(jsonData)
-		}
-
-		conn, _ := tls.Dial("tcp", s.thisServer.Address(), s.thisServer.ID.Config)
-		conn.Write(jsonData)
+		s.postToChannel(msg)
 	}
 }
 
-func (server *Server) CreateChannel(name string) {
-	server.thisServer.Channel = model.NewChannel(name)
-	msg := model.Message{Type: headerType.NewChannel, Hostname: server.thisServer.Hostname, Port: server.thisServer.Port, Content: name, Nickname: server.thisServer.Nickname, Timestamp: time.Now(), HashID: server.thisServer.HashID()}
+// postToChannel checks msg against the channel settings, seals it if the
+// channel is encrypted and sends it to every member and to ourselves.
+func (s *Server) postToChannel(msg model.Message) {
+	msg.Channel = s.thisServer.Channel.ChannelName
+	if err := s.thisServer.Channel.CheckMessage(msg); err != nil {
+		fmt.Println("Message not sent:", err)
+		return
+	}
+
+	if err := s.sealChannelMessage(&msg); err != nil {
+		fmt.Println("Message not sent:", err)
+		return
+	}
 
-	jsonData, err := json.Marshal(msg)
+	jsonData, err := s.encodeMessage(&msg)
 	if err != nil {
 		fmt.Println("Error encoding JSON:", err)
+		return
+	}
+	if len(jsonData) > s.profile.Settings.Limits.MaxMessageBytes {
+		fmt.Printf("Message not sent: larger than the %d byte limit\n", s.profile.Settings.Limits.MaxMessageBytes)
+		return
 	}
 
-	for _, node := range server.knownNodes {
+	s.thisServer.Channel.MarkPosted(msg, time.Now())
+	for _, node := range s.thisServer.Channel.ConnectedNodes {
 		node.Connection.Write(jsonData)
 	}
 
-	conn, _ := tls.Dial("tcp", server.thisServer.Address(), server.thisServer.ID.Config)
+	conn, _ := tls.Dial("tcp", s.thisServer.Address(), s.thisServer.ID.DialConfig(s.thisServer.Address(), s.thisServer.PublicKey))
 	conn.Write(jsonData)
 }
 
-func (server *Server) ChangeChannel(channel string) {
-	server.thisServer.Channel = model.NewChannel(channel)
-	msg := model.Message{Type: headerType.UpdateChannel, Hostname: server.thisServer.Hostname, Port: server.thisServer.Port, Content: channel, Nickname: server.thisServer.Nickname, Timestamp: time.Now(), HashID: server.thisServer.HashID()}
+// CreateChannel creates a channel and moves this node into it. An encrypted
+// channel starts with a fresh key held only by its creator; a password
+// channel needs the password to be non-empty.
+func (server *Server) CreateChannel(name string, encrypted bool, access accessMode.Mode, password string) {
+	metadata, err := model.NewChannelMetadata(name, model.ChannelSettings{Encrypted: encrypted, Access: access}, server.thisServer.ID.PrivateKey, server.thisServer.PublicKey)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	channel := model.NewChannel(name)
+	channel.Metadata = metadata
+	channel.Admitted = true
+	if err := server.prepareJoin(&channel, password); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	server.leaveChannelKeys()
+	server.thisServer.Channel = channel
+	if encrypted {
+		server.generateChannelKey()
+	}
+
+	channelJSON, err := json.Marshal(model.Channel{ChannelName: name, Metadata: metadata})
+	if err != nil {
+		fmt.Println("Error encoding channel JSON:", err)
+		return
+	}
 
-	jsonData, err := json.Marshal(msg)
+	msg := model.Message{Type: headerType.NewChannel, Hostname: server.thisServer.Hostname, Port: server.thisServer.Port, Content: string(channelJSON), Nickname: server.thisServer.Nickname, Timestamp: time.Now(), HashID: server.thisServer.HashID()}
+
+	jsonData, err := server.encodeMessage(&msg)
 	if err != nil {
 		fmt.Println("Error encoding JSON:", err)
 	}
@@ -353,6 +741,58 @@ func (server *Server) ChangeChannel(channel string) {
 	for _, node := range server.knownNodes {
 		node.Connection.Write(jsonData)
 	}
+
+	conn, _ := tls.Dial("tcp", server.thisServer.Address(), server.thisServer.ID.DialConfig(server.thisServer.Address(), server.thisServer.PublicKey))
+	conn.Write(jsonData)
+}
+
+// ChangeChannel moves this node into channel. credential is the password
+// or invite token for channels that are not public.
+func (server *Server) ChangeChannel(channel, credential string) {
+	if known, exists := server.channels[channel]; exists && known.Banned[server.thisServer.HashID()] {
+		fmt.Println("You are banned from", channel)
+		return
+	}
+
+	joined := model.NewChannel(channel)
+	if known, exists := server.channels[channel]; exists {
+		if known.Creator() != "" {
+			joined.Found(known.Metadata)
+		}
+		joined.Adopt(*known)
+	}
+	if err := server.prepareJoin(&joined, credential); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	server.loadChannelHistory(&joined)
+
+	server.leaveChannelKeys()
+	server.thisServer.Channel = joined
+
+	for _, node := range server.knownNodes {
+		server.announceChannel(node)
+	}
+}
+
+// announceChannel tells node which channel this node is in.
+func (server *Server) announceChannel(node *model.Node) {
+	content, err := json.Marshal(server.joinRequest())
+	if err != nil {
+		fmt.Println("Error encoding join request:", err)
+		return
+	}
+
+	msg := model.Message{Type: headerType.UpdateChannel, Hostname: server.thisServer.Hostname, Port: server.thisServer.Port, Content: string(content), Nickname: server.thisServer.Nickname, Timestamp: time.Now(), HashID: server.thisServer.HashID()}
+
+	jsonData, err := server.encodeMessage(&msg)
+	if err != nil {
+		fmt.Println("Error encoding JSON:", err)
+		return
+	}
+
+	node.Connection.Write(jsonData)
 }
 
 func (server *Server) poll() {
@@ -376,7 +816,7 @@ func (server *Server) pollNode(hashId string, node model.Node, wg *sync.WaitGrou
 		fmt.Println("Node timed out:", node.Address(), err)
 		server.removeNode(hashId)
 	} else {
-		tlsConn := tls.Client(conn, server.thisServer.ID.Config)
+		tlsConn := tls.Client(conn, server.thisServer.ID.DialConfig(node.Address(), node.PublicKey))
 		tlsConn.SetDeadline(time.Now().Add(timeout))
 		err = tlsConn.Handshake()
 
@@ -397,17 +837,19 @@ func (server *Server) removeNode(hashId string) {
 	if node, exists := server.thisServer.Channel.ConnectedNodes[hashId]; exists {
 		fmt.Println("Connection timed out:", node.Nickname)
 		delete(server.thisServer.Channel.ConnectedNodes, hashId)
-	} else {
-		for _, channel := range server.channels {
-			if _, exists := channel.ConnectedNodes[hashId]; exists {
-				delete(channel.ConnectedNodes, hashId)
-			}
+		server.memberLeft()
+	}
+	for _, channel := range server.channels {
+		if _, exists := channel.ConnectedNodes[hashId]; exists {
+			delete(channel.ConnectedNodes, hashId)
 		}
 	}
 	delete(server.knownNodes, hashId)
 }
 
 func (server *Server) startPolling(wg *sync.WaitGroup) {
+	defer wg.Done()
+
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
@@ -417,8 +859,6 @@ func (server *Server) startPolling(wg *sync.WaitGroup) {
 			server.poll()
 		}
 	}
-
-	wg.Done()
 }
 
 func (s *Server) loadMirrors() {
@@ -483,59 +923,132 @@ func (server *Server) start() {
 	wg.Wait()
 }
 
+// chooseName reads the nickname without buffering stdin, so a passphrase
+// piped in after it is still there to be read.
 func chooseName() string {
-	reader := bufio.NewReader(os.Stdin)
 	fmt.Print("Enter nickname: ")
-	text, _ := reader.ReadString('\n')
+	text, _ := utils.ReadLine()
 	return text
 }
 
-func generateIdentification(host, port string) *model.Identification {
-	if _, err := os.Stat("../certs/key.pem"); errors.Is(err, os.ErrNotExist) {
-		certs.GenerateCert(host, port)
+func main() {
+	profileName := flag.String("profile", profile.DefaultName, "profile to run as, each has its own identity, known peers, history and settings")
+	profilesDir := flag.String("profiles-dir", profile.DefaultBaseDir, "directory containing the profiles")
+	listProfiles := flag.Bool("list-profiles", false, "list existing profiles and exit")
+	keyAlg := flag.String("keyalg", "", "key algorithm for a new identity: ed25519 (default), ecdsa-p256 or rsa")
+	passphraseFile := flag.String("passphrase-file", "", "read the key passphrase from this file instead of prompting")
+	plaintextKey := flag.Bool("insecure-plaintext-key", false, "allow a new identity key to be stored without a passphrase")
+	flag.Parse()
+
+	if *listProfiles {
+		names, err := profile.List(*profilesDir)
+		if err != nil {
+			fmt.Println("Error listing profiles:", err)
+			return
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return
 	}
 
-	keyStr, _ := os.ReadFile("../certs/key.pem")
-	keyBlock, _ := pem.Decode(keyStr)
-	pk, _ := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
-
-	certStr, _ := os.ReadFile("../certs/cert.pem")
-
-	tlsCert, err := tls.X509KeyPair(certStr, keyStr)
+	p, err := profile.Load(*profilesDir, *profileName)
 	if err != nil {
-		panic(err)
+		fmt.Println("Error loading profile:", err)
+		os.Exit(1)
 	}
 
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{tlsCert},
-		MinVersion:   tls.VersionTLS12,
+	// Address and nickname default to what the profile last ran with.
+	hostname, port := p.Settings.Hostname, p.Settings.Port
+	if flag.NArg() >= 2 {
+		hostname = flag.Arg(0)
+		port = flag.Arg(1)
 	}
 
-	return &model.Identification{PrivateKey: pk, Certificate: &tlsCert, Config: tlsConfig}
-}
-
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: go run main.go <hostname> <port>")
+	if hostname == "" || port == "" {
+		fmt.Println("Usage: go run main.go [-profile name] [-keyalg algorithm] [-passphrase-file file] [-insecure-plaintext-key] <hostname> <port>")
 		return
 	}
 
-	hostname := os.Args[1]
-	port := os.Args[2]
 	if hostname == "localhost" {
 		hostname = "[::1]"
 	}
 
-	nickname := chooseName()
-	identification := generateIdentification(hostname, port)
+	alg := p.Settings.KeyAlgorithm
+	if *keyAlg != "" {
+		alg = keyAlgorithm.Algorithm(*keyAlg)
+	}
+	if alg == "" {
+		alg = keyAlgorithm.Default
+	}
+
+	nickname := p.Settings.Nickname
+	if nickname == "" {
+		nickname = chooseName()
+	}
+
+	identification, err := generateIdentification(p, hostname, port, alg, *passphraseFile, *plaintextKey)
+	if err != nil {
+		fmt.Println("Error loading identity:", err)
+		os.Exit(1)
+	}
+
+	p.Settings.Hostname, p.Settings.Port, p.Settings.Nickname = hostname, port, strings.TrimSpace(nickname)
+	if p.Settings.KeyAlgorithm == "" {
+		p.Settings.KeyAlgorithm = alg
+	}
+	if err := p.Save(); err != nil {
+		fmt.Println(err)
+	}
+
+	serverNode := model.Node{Hostname: hostname, Port: port, Nickname: nickname, Channel: defaultChannel, ID: identification, PublicKey: publicKeyBytes(identification)}
+	serverNode.EncryptionKey, serverNode.EncryptionKeySig = signedEncryptionKey(identification)
+
+	sessions, err := e2e.NewSessionStore(p.Path(profile.SessionsDir), identification.EncryptionKey)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	messageHistory, err := history.Open(p.Path(profile.HistoryDir), identification.EncryptionKey)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	revocations, err := model.LoadRevocationList(p.Path(profile.RevocationsFile))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	serverNode := model.Node{Hostname: hostname, Port: port, Nickname: nickname, Channel: defaultChannel, ID: identification}
+	server := &Server{
+		thisServer:            serverNode,
+		knownNodes:            make(map[string]*model.Node),
+		knownMirrors:          []model.Node{},
+		channels:              defaultChans,
+		privateMessageHistory: make(map[string][]model.Message),
+		unconfirmed:           make(map[string][]string),
+		refused:               make(map[string]model.Node),
+		profile:               p,
+		sessions:              sessions,
+		history:               messageHistory,
+		channelKeys:           make(map[string]*channelKeyring),
+		verified:              loadVerifiedContacts(p.Path(profile.VerifiedFile)),
+		blocks:                loadBlockList(p.Path(profile.BlockListFile)),
+		limiter:               newRateLimiter(p.Settings.Limits),
+		replays:               newReplayCache(),
+		revocations:           revocations,
+	}
 
-	server := &Server{serverNode, make(map[string]*model.Node), []model.Node{}, defaultChans, make(map[string][]model.Message)}
+	server.restoreHistory()
+	server.checkCertificate()
 
 	var pollWG sync.WaitGroup
 	pollWG.Add(1)
 	go server.startPolling(&pollWG)
+	go server.startRenewal()
+	go server.startCertMonitor()
 	server.start()
 
 	pollWG.Wait()
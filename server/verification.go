@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-p2p/model"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// verifiedContact is a peer the user confirmed by comparing safety numbers,
+// with the keys that were confirmed at the time.
+type verifiedContact struct {
+	Nickname      string    `json:"nickname"`
+	PublicKey     []byte    `json:"publicKey"`
+	EncryptionKey []byte    `json:"encryptionKey"`
+	VerifiedAt    time.Time `json:"verifiedAt"`
+	// Changed is set once the contact presents a key other than the one
+	// verified, and cleared by verifying again.
+	Changed bool `json:"changed,omitempty"`
+}
+
+// verifiedContacts is the profile's verified.json, keyed by identity.
+type verifiedContacts struct {
+	path     string
+	contacts map[string]*verifiedContact
+}
+
+func loadVerifiedContacts(path string) *verifiedContacts {
+	v := &verifiedContacts{path: path, contacts: make(map[string]*verifiedContact)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("Error reading verified contacts:", err)
+		}
+		return v
+	}
+
+	if err := json.Unmarshal(data, &v.contacts); err != nil {
+		fmt.Println("Error decoding verified contacts:", err)
+	}
+	return v
+}
+
+func (v *verifiedContacts) save() {
+	data, err := json.MarshalIndent(v.contacts, "", "  ")
+	if err != nil {
+		fmt.Println("Error encoding verified contacts:", err)
+		return
+	}
+
+	if err := os.WriteFile(v.path, data, 0600); err != nil {
+		fmt.Println("Error writing verified contacts:", err)
+	}
+}
+
+func (v *verifiedContacts) status(hashId string) string {
+	contact, exists := v.contacts[hashId]
+	switch {
+	case !exists:
+		return "not verified"
+	case contact.Changed:
+		return "KEY CHANGED since verification"
+	default:
+		return "verified " + contact.VerifiedAt.Format("2006-01-02")
+	}
+}
+
+// checkVerified warns when a peer no longer matches what the user verified:
+// either a verified identity now uses another encryption key, or a different
+// identity turns up under a verified contact's nickname.
+func (s *Server) checkVerified(node *model.Node) {
+	nickname := strings.TrimSpace(node.Nickname)
+
+	if contact, exists := s.verified.contacts[node.HashID()]; exists {
+		if !contact.Changed && !bytes.Equal(contact.EncryptionKey, node.EncryptionKey) {
+			contact.Changed = true
+			s.verified.save()
+			fmt.Println("WARNING: the encryption key of verified contact", nickname, "has changed.")
+			fmt.Println("Compare safety numbers again with /safety", nickname)
+		}
+		return
+	}
+
+	// The mirror hands a taken nickname to another key as "name#fingerprint",
+	// so compare the part before the suffix.
+	base, _, _ := strings.Cut(nickname, "#")
+	for hashId, contact := range s.verified.contacts {
+		if contactBase, _, _ := strings.Cut(strings.TrimSpace(contact.Nickname), "#"); contactBase == base {
+			fmt.Printf("WARNING: %s is not the verified contact of that name (identity %s, verified %s).\n", nickname, node.HashID()[:16], hashId[:16])
+		}
+	}
+}
+
+func (s *Server) showSafetyNumber(node *model.Node) {
+	nickname := strings.TrimSpace(node.Nickname)
+	groups := strings.Fields(model.SafetyNumber(s.thisServer.PublicKey, node.PublicKey))
+
+	fmt.Printf("Safety number with %s (%s):\n", nickname, s.verified.status(node.HashID()))
+	for i := 0; i < len(groups); i += 4 {
+		fmt.Println("  " + strings.Join(groups[i:i+4], " "))
+	}
+	fmt.Printf("Compare it with %s over a channel you trust, then run /verify %s\n", nickname, nickname)
+}
+
+func (s *Server) listVerified() {
+	if len(s.verified.contacts) == 0 {
+		fmt.Println("No verified contacts")
+		return
+	}
+
+	ids := make([]string, 0, len(s.verified.contacts))
+	for hashId := range s.verified.contacts {
+		ids = append(ids, hashId)
+	}
+	sort.Strings(ids)
+
+	for _, hashId := range ids {
+		fmt.Printf("%s  %s  %s\n", hashId[:16], strings.TrimSpace(s.verified.contacts[hashId].Nickname), s.verified.status(hashId))
+	}
+}
+
+func (s *Server) verifyContact(node *model.Node) {
+	s.verified.contacts[node.HashID()] = &verifiedContact{Nickname: strings.TrimSpace(node.Nickname), PublicKey: node.PublicKey, EncryptionKey: node.EncryptionKey, VerifiedAt: time.Now()}
+	s.verified.save()
+	fmt.Println(strings.TrimSpace(node.Nickname), "marked as verified")
+}
+
+// unverifyContact removes a verification by nickname or identity prefix, so
+// contacts that are offline can be removed too.
+func (s *Server) unverifyContact(query string) {
+	for hashId, contact := range s.verified.contacts {
+		if strings.TrimSpace(contact.Nickname) == query || (len(query) >= 8 && strings.HasPrefix(hashId, query)) {
+			delete(s.verified.contacts, hashId)
+			s.verified.save()
+			fmt.Println(contact.Nickname, "is no longer verified")
+			return
+		}
+	}
+	fmt.Println("No verified contact called", query)
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ReadLine reads one line from stdin a byte at a time, so nothing past the
+// newline is consumed and later reads of stdin see the following lines.
+func ReadLine() (string, error) {
+	var sb strings.Builder
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n == 1 {
+			if buf[0] == '\n' {
+				return strings.TrimRight(sb.String(), "\r"), nil
+			}
+			sb.WriteByte(buf[0])
+		}
+		if err == io.EOF && sb.Len() > 0 {
+			return strings.TrimRight(sb.String(), "\r"), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// ReadPassphrase prompts on stdin without echoing when it is a terminal.
+func ReadPassphrase(prompt string) ([]byte, error) {
+	fmt.Print(prompt)
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		pass, err := term.ReadPassword(fd)
+		fmt.Println()
+		return pass, err
+	}
+
+	line, err := ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(line), nil
+}